@@ -4,23 +4,157 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type ApiGatewayConfig struct {
-	Server BindAddressConfig `yaml:"server"`
-	Routes []RouteConfig     `yaml:"routes"`
+	Server      BindAddressConfig `yaml:"server"`
+	RetryBudget RetryBudgetConfig `yaml:"retry_budget"`
+	Reload      ReloadConfig      `yaml:"reload"`
+	Admin       AdminConfig       `yaml:"admin"`
+	RequestId   RequestIdConfig   `yaml:"request_id"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	AccessLog   AccessLogConfig   `yaml:"access_log"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Consumers   []ConsumerConfig  `yaml:"consumers"`
+	Filters     []FilterConfig    `yaml:"filters"`
+	Routes      []RouteConfig     `yaml:"routes"`
+}
+
+// ConsumerConfig is a client authenticated by the key_auth filter. KeyHashes
+// are the hex encoded SHA-256 hashes of its API keys, so that the keys
+// themselves are not stored in the configuration.
+type ConsumerConfig struct {
+	Id        string   `yaml:"id"`
+	Groups    []string `yaml:"groups"`
+	KeyHashes []string `yaml:"key_hashes" secret:"true"`
+}
+
+type RequestIdConfig struct {
+	Header string `yaml:"header"`
+	Format string `yaml:"format"`
+}
+
+// AccessLogConfig configures the access log, which is written separately from
+// the application log. Without it, requests are logged to the application log.
+type AccessLogConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Format   string            `yaml:"format"`
+	Template string            `yaml:"template"`
+	Output   string            `yaml:"output"`
+	Rotation LogRotationConfig `yaml:"rotation"`
+}
+
+// LoggingConfig configures the application log. Packages sets the levels of
+// the loggers of individual packages, e.g. proxy: debug.
+type LoggingConfig struct {
+	Level       string             `yaml:"level"`
+	Encoding    string             `yaml:"encoding"`
+	OutputPaths []string           `yaml:"output_paths"`
+	Sampling    *LogSamplingConfig `yaml:"sampling"`
+	Packages    map[string]string  `yaml:"packages"`
+}
+
+type LogSamplingConfig struct {
+	Initial    int `yaml:"initial"`
+	Thereafter int `yaml:"thereafter"`
+}
+
+type LogRotationConfig struct {
+	MaxSizeMB  int           `yaml:"max_size_mb"`
+	Interval   time.Duration `yaml:"interval"`
+	MaxBackups int           `yaml:"max_backups"`
+}
+
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	ServiceName string            `yaml:"service_name"`
+	SampleRatio *float64          `yaml:"sample_ratio"`
+	Propagation []string          `yaml:"propagation"`
+	Exporter    string            `yaml:"exporter"`
+	Endpoint    string            `yaml:"endpoint"`
+	Headers     map[string]string `yaml:"headers" secret:"true"`
+	File        string            `yaml:"file"`
+}
+
+type AdminConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	PersistFile string `yaml:"persist_file"`
+}
+
+type ReloadConfig struct {
+	WatchFile bool          `yaml:"watch_file"`
+	Interval  time.Duration `yaml:"interval"`
 }
 
 type BindAddressConfig struct {
-	Address string `yaml:"address"`
-	Port    int    `yaml:"port"`
+	Address           string              `yaml:"address"`
+	Port              int                 `yaml:"port"`
+	ReadTimeout       time.Duration       `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration       `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration       `yaml:"write_timeout"`
+	IdleTimeout       time.Duration       `yaml:"idle_timeout"`
+	Management        *BindAddressConfig  `yaml:"management,omitempty"`
+	TLS               []TLSListenerConfig `yaml:"tls,omitempty"`
+	TrustedProxies    []string            `yaml:"trusted_proxies,omitempty"`
+}
+
+// TLSListenerConfig configures an HTTPS listener. The certificate is selected
+// by the server name the client asks for (SNI), the first certificate is used
+// for clients not sending one.
+type TLSListenerConfig struct {
+	Address        string              `yaml:"address"`
+	Port           int                 `yaml:"port"`
+	Certificates   []CertificateConfig `yaml:"certificates"`
+	MinVersion     string              `yaml:"min_version"`
+	CipherSuites   []string            `yaml:"cipher_suites"`
+	ALPN           []string            `yaml:"alpn"`
+	ReloadInterval time.Duration       `yaml:"reload_interval"`
+}
+
+type CertificateConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file" secret:"true"`
+	OCSPStapleFile string `yaml:"ocsp_staple_file"`
+}
+
+type RetryBudgetConfig struct {
+	Percent             float64 `yaml:"percent"`
+	MinRetriesPerSecond int     `yaml:"min_retries_per_second"`
 }
 
 type RouteConfig struct {
 	FrontendConfig `yaml:"frontend"`
 	BackendConfig  `yaml:"backend"`
+	Retry          RetryConfig    `yaml:"retry"`
+	Timeouts       TimeoutsConfig `yaml:"timeouts"`
+	Filters        []FilterConfig `yaml:"filters"`
+}
+
+// FilterConfig names a middleware applied to the requests of the gateway, or
+// of a route, and its parameters.
+type FilterConfig struct {
+	Name   string                 `yaml:"name"`
+	Params map[string]interface{} `yaml:"params" secret:"params"`
+}
+
+type TimeoutsConfig struct {
+	Connect        time.Duration `yaml:"connect"`
+	ResponseHeader time.Duration `yaml:"response_header"`
+	Total          time.Duration `yaml:"total"`
+}
+
+type RetryConfig struct {
+	MaxAttempts     int           `yaml:"max_attempts"`
+	RetryOnStatus   []int         `yaml:"retry_on_status"`
+	RetryOnErrors   []string      `yaml:"retry_on_errors"`
+	BaseBackoff     time.Duration `yaml:"base_backoff"`
+	MaxBackoff      time.Duration `yaml:"max_backoff"`
+	BufferBodyLimit int64         `yaml:"buffer_body_limit"`
 }
 
 type FrontendConfig struct {
@@ -29,21 +163,158 @@ type FrontendConfig struct {
 }
 
 type BackendConfig struct {
-	Url string `yaml:"url"`
+	Url            string               `yaml:"url" secret:"url"`
+	Targets        []TargetConfig       `yaml:"targets"`
+	LoadBalancer   LoadBalancerConfig   `yaml:"load_balancer"`
+	HealthCheck    HealthCheckConfig    `yaml:"health_check"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	StripPrefix    string               `yaml:"strip_prefix"`
+	AddPrefix      string               `yaml:"add_prefix"`
+	TLS            UpstreamTLSConfig    `yaml:"tls"`
+	Transport      TransportConfig      `yaml:"transport"`
+	Concurrency    ConcurrencyConfig    `yaml:"concurrency"`
+	PreserveHost   bool                 `yaml:"preserve_host"`
+}
+
+// ConcurrencyConfig limits the requests in flight to each target of the
+// backend. Requests beyond the limit wait in a queue of max_queue requests for
+// at most queue_timeout.
+type ConcurrencyConfig struct {
+	MaxInFlight  int                       `yaml:"max_in_flight"`
+	MaxQueue     int                       `yaml:"max_queue"`
+	QueueTimeout time.Duration             `yaml:"queue_timeout"`
+	RetryAfter   time.Duration             `yaml:"retry_after"`
+	Adaptive     AdaptiveConcurrencyConfig `yaml:"adaptive"`
+}
+
+// AdaptiveConcurrencyConfig adjusts the limit of requests in flight, starting
+// at max_in_flight, from the latencies of the target.
+type AdaptiveConcurrencyConfig struct {
+	Algorithm        string        `yaml:"algorithm"`
+	MinLimit         int           `yaml:"min_limit"`
+	MaxLimit         int           `yaml:"max_limit"`
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	BackoffRatio     float64       `yaml:"backoff_ratio"`
+	Tolerance        float64       `yaml:"tolerance"`
+	Smoothing        float64       `yaml:"smoothing"`
+}
+
+// TransportConfig tunes the connection pool to the backend. The dial and
+// response header timeouts are set in the timeouts of the route.
+type TransportConfig struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	KeepAlive           time.Duration `yaml:"keep_alive"`
+	DisableHTTP2        bool          `yaml:"disable_http2"`
+}
+
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file" secret:"true"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 }
 
+type TargetConfig struct {
+	Url    string `yaml:"url" secret:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+type LoadBalancerConfig struct {
+	Strategy string `yaml:"strategy"`
+	HashOn   string `yaml:"hash_on"`
+	HashKey  string `yaml:"hash_key"`
+}
+
+type HealthCheckConfig struct {
+	Path               string            `yaml:"path"`
+	Interval           time.Duration     `yaml:"interval"`
+	Timeout            time.Duration     `yaml:"timeout"`
+	ExpectedStatus     StatusRangeConfig `yaml:"expected_status"`
+	ExpectedBody       string            `yaml:"expected_body"`
+	HealthyThreshold   int               `yaml:"healthy_threshold"`
+	UnhealthyThreshold int               `yaml:"unhealthy_threshold"`
+}
+
+type CircuitBreakerConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	ConsecutiveFailures int           `yaml:"consecutive_failures"`
+	ErrorRate           float64       `yaml:"error_rate"`
+	Window              time.Duration `yaml:"window"`
+	MinRequests         int           `yaml:"min_requests"`
+	LatencyThreshold    time.Duration `yaml:"latency_threshold"`
+	OpenTimeout         time.Duration `yaml:"open_timeout"`
+	HalfOpenRequests    int           `yaml:"half_open_requests"`
+	FailFastStatus      int           `yaml:"fail_fast_status"`
+	FailFastBody        string        `yaml:"fail_fast_body"`
+}
+
+type StatusRangeConfig struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+const (
+	LoadBalancerRoundRobin         = "round_robin"
+	LoadBalancerWeightedRoundRobin = "weighted_round_robin"
+	LoadBalancerLeastOutstanding   = "least_outstanding"
+	LoadBalancerRandomTwoChoices   = "random_two_choices"
+	LoadBalancerConsistentHash     = "consistent_hash"
+
+	HashOnHeader   = "header"
+	HashOnCookie   = "cookie"
+	HashOnClientIP = "ip"
+)
+
 func (b *BindAddressConfig) GetListenAddress() string {
 	return fmt.Sprintf("%s:%d", b.Address, b.Port)
 }
 
-func (b *BackendConfig) GetUrl() (*url.URL, error) {
-	backendUrl, err := url.ParseRequestURI(b.Url)
+func (t *TLSListenerConfig) GetListenAddress() string {
+	return fmt.Sprintf("%s:%d", t.Address, t.Port)
+}
+
+// GetTargets returns the configured targets, including the target given by the
+// url shorthand, if any.
+func (b *BackendConfig) GetTargets() []TargetConfig {
+	if b.Url == "" {
+		return b.Targets
+	}
+	return append([]TargetConfig{{Url: b.Url, Weight: 1}}, b.Targets...)
+}
+
+func (t *TargetConfig) GetUrl() (*url.URL, error) {
+	backendUrl, err := url.ParseRequestURI(t.Url)
 	if err != nil {
 		return nil, err
 	}
 	return backendUrl, nil
 }
 
+// Enabled reports whether a request may be attempted more than once.
+func (r *RetryConfig) Enabled() bool {
+	return r.MaxAttempts > 1
+}
+
+// Enabled reports whether upstream TLS settings other than the defaults are
+// configured.
+func (u *UpstreamTLSConfig) Enabled() bool {
+	return *u != UpstreamTLSConfig{}
+}
+
+// Enabled reports whether the requests in flight to the targets are limited.
+func (c *ConcurrencyConfig) Enabled() bool {
+	return c.MaxInFlight > 0
+}
+
+// Enabled reports whether active health checking is configured.
+func (h *HealthCheckConfig) Enabled() bool {
+	return h.Path != ""
+}
+
 func LoadConfig(filePath string) (*ApiGatewayConfig, error) {
 	cfg := &ApiGatewayConfig{}
 
@@ -58,3 +329,32 @@ func LoadConfig(filePath string) (*ApiGatewayConfig, error) {
 
 	return cfg, nil
 }
+
+// SaveConfig writes the configuration to the file, replacing the file only
+// once the configuration was written completely.
+func SaveConfig(filePath string, cfg *ApiGatewayConfig) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filePath)
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	redactedValue = "******"
+	// redactedUrlPassword replaces passwords in URLs, see url.URL.Redacted.
+	redactedUrlPassword = "xxxxx"
+)
+
+// Redact returns a copy of the configuration that is safe to display.
+//
+// String fields tagged `secret:"true"` are replaced entirely, as are the
+// elements of string slices and the values of string maps tagged so. String
+// fields tagged `secret:"url"` only have the password of the URL replaced. Maps
+// of middleware parameters tagged `secret:"params"` have the values of secret
+// parameters, e.g. password, replaced.
+func Redact(cfg *ApiGatewayConfig) *ApiGatewayConfig {
+	if cfg == nil {
+		return nil
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return &ApiGatewayConfig{}
+	}
+	redacted := &ApiGatewayConfig{}
+	if err := yaml.Unmarshal(data, redacted); err != nil {
+		return &ApiGatewayConfig{}
+	}
+
+	redactValue(reflect.ValueOf(redacted).Elem())
+	return redacted
+}
+
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			redactValue(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			redactValue(v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			redactValue(elem)
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := v.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+
+			switch t.Field(i).Tag.Get("secret") {
+			case "true":
+				redactSecret(field)
+			case "url":
+				if field.Kind() == reflect.String {
+					field.SetString(redactUrl(field.String()))
+				}
+			case "params":
+				if params, ok := field.Interface().(map[string]interface{}); ok {
+					redactParams(params)
+				}
+			default:
+				redactValue(field)
+			}
+		}
+	}
+}
+
+func redactSecret(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() > 0 {
+			v.SetString(redactedValue)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			redactSecret(v.Index(i))
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() == reflect.String {
+			for _, key := range v.MapKeys() {
+				v.SetMapIndex(key, reflect.ValueOf(redactedValue).Convert(v.Type().Elem()))
+			}
+		}
+	}
+}
+
+var secretParams = map[string]bool{
+	"password": true,
+	"secret":   true,
+	"token":    true,
+	"api_key":  true,
+}
+
+func redactParams(params interface{}) {
+	switch p := params.(type) {
+	case map[string]interface{}:
+		for key, value := range p {
+			if secretParams[strings.ToLower(key)] {
+				p[key] = redactedValue
+			} else {
+				redactParams(value)
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, value := range p {
+			if name, ok := key.(string); ok && secretParams[strings.ToLower(name)] {
+				p[key] = redactedValue
+			} else {
+				redactParams(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range p {
+			redactParams(value)
+		}
+	}
+}
+
+// redactUrl replaces the password of the URL the way the routes endpoint of the
+// management server shows target URLs.
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	return u.Redacted()
+}
+
+// IsRedacted reports whether the configuration holds values replaced by Redact,
+// e.g. when a displayed configuration is written back.
+func IsRedacted(cfg *ApiGatewayConfig) bool {
+	return cfg != nil && isRedactedValue(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func isRedactedValue(v reflect.Value, secret string) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return !v.IsNil() && isRedactedValue(v.Elem(), secret)
+	case reflect.String:
+		switch secret {
+		case "true":
+			return v.String() == redactedValue
+		case "url":
+			u, err := url.Parse(v.String())
+			if err != nil || u.User == nil {
+				return false
+			}
+			password, _ := u.User.Password()
+			return password == redactedUrlPassword
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if isRedactedValue(v.Index(i), secret) {
+				return true
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if isRedactedValue(v.MapIndex(key), secret) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			tag := t.Field(i).Tag.Get("secret")
+			if tag == "params" {
+				if isRedactedParams(v.Field(i).Interface()) {
+					return true
+				}
+			} else if isRedactedValue(v.Field(i), tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isRedactedParams(params interface{}) bool {
+	switch p := params.(type) {
+	case map[string]interface{}:
+		for key, value := range p {
+			if secretParams[strings.ToLower(key)] && value == redactedValue || isRedactedParams(value) {
+				return true
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, value := range p {
+			name, _ := key.(string)
+			if secretParams[strings.ToLower(name)] && value == redactedValue || isRedactedParams(value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, value := range p {
+			if isRedactedParams(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
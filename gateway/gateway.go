@@ -0,0 +1,480 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cdmatta/api-gw/config"
+	"github.com/cdmatta/api-gw/logfile"
+	"github.com/cdmatta/api-gw/logging"
+	"github.com/cdmatta/api-gw/management"
+	"github.com/cdmatta/api-gw/middleware"
+	"github.com/cdmatta/api-gw/proxy"
+	"github.com/cdmatta/api-gw/tracing"
+	"go.uber.org/zap"
+)
+
+// Main runs the gateway with the configuration file given as argument. Custom
+// gateway binaries call it from their main function, after importing the
+// packages registering their middlewares, see middleware.RegisterMiddleware.
+func Main(version management.VersionInfo) {
+	logger, _, _ := logging.NewLogger(config.LoggingConfig{})
+	zap.ReplaceGlobals(logger)
+
+	if len(os.Args) == 1 {
+		zap.S().Fatalf("usage: %s <config-file>", os.Args[0])
+	}
+
+	configFile := os.Args[1]
+	apiGwConfig, err := config.LoadConfig(configFile)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
+	logger, logLevels, err := logging.NewLogger(apiGwConfig.Logging)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	zap.ReplaceGlobals(logger)
+	defer logger.Sync()
+	zap.S().Infof("%+v", config.Redact(apiGwConfig))
+
+	consumers, err := newConsumers(apiGwConfig.Consumers)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	middleware.SetConsumers(consumers)
+
+	requestId, err := middleware.NewRequestIdMiddleware().
+		WithHeader(apiGwConfig.RequestId.Header).
+		WithFormat(apiGwConfig.RequestId.Format)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
+	accessLoggingMetrics := middleware.NewAccessLoggingMetricsMiddleware()
+	if apiGwConfig.AccessLog.Enabled {
+		accessLog, err := newAccessLog(apiGwConfig.AccessLog)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		accessLoggingMetrics.WithAccessLog(accessLog)
+	}
+
+	middlewares := []middleware.Middleware{requestId, accessLoggingMetrics}
+	if apiGwConfig.Tracing.Enabled {
+		tracer, err := newTracer(apiGwConfig.Tracing)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		defer tracer.Shutdown()
+		middlewares = append(middlewares, middleware.NewTracingMiddleware(tracer))
+	}
+
+	filters, err := newFilters(apiGwConfig.Filters)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	middlewares = append(middlewares, filters...)
+
+	gateway := proxy.NewReverseProxy().WithGlobalFilterFunc(middleware.Compose(middlewares...))
+
+	gateway.WithServerTimeouts(proxy.ServerTimeouts{
+		Read:       apiGwConfig.Server.ReadTimeout,
+		ReadHeader: apiGwConfig.Server.ReadHeaderTimeout,
+		Write:      apiGwConfig.Server.WriteTimeout,
+		Idle:       apiGwConfig.Server.IdleTimeout,
+	})
+
+	trustedProxies, err := proxy.NewTrustedProxies(apiGwConfig.Server.TrustedProxies...)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	gateway.WithTrustedProxies(trustedProxies)
+
+	if rb := apiGwConfig.RetryBudget; rb.Percent > 0 {
+		gateway.WithRetryBudget(proxy.NewRetryBudget(rb.Percent, rb.MinRetriesPerSecond))
+	}
+
+	if err := checkRateLimitKeys(apiGwConfig.Filters, apiGwConfig.Routes); err != nil {
+		zap.S().Fatal(err)
+	}
+	routes, err := newRoutes(apiGwConfig.Routes)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	if err := gateway.SetRoutes(routes); err != nil {
+		zap.S().Fatal(err)
+	}
+
+	reloader := newConfigReloader(configFile, apiGwConfig, routes, gateway)
+	go reloader.watchSignals()
+	if apiGwConfig.Reload.WatchFile {
+		go reloader.watchFile(apiGwConfig.Reload.Interval)
+	}
+
+	if managementConfig := apiGwConfig.Server.Management; managementConfig != nil {
+		managementServer := management.NewServer(gateway, version, reloader.currentConfig)
+		if apiGwConfig.Admin.Enabled {
+			managementServer.Handle("/admin/routes", management.NewAdminRoutesHandler(reloader))
+			managementServer.Handle("/admin/logging", logLevels)
+		}
+
+		go func() {
+			zap.S().Infof("Starting management server on %s", managementConfig.GetListenAddress())
+			zap.S().Fatal(managementServer.ListenAndServe(managementConfig.GetListenAddress()))
+		}()
+		managementServer.SetReady(true)
+	}
+
+	for _, listenerConfig := range apiGwConfig.Server.TLS {
+		certificates, tlsConfig, err := newTLSConfig(listenerConfig)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		go certificates.Watch(listenerConfig.ReloadInterval)
+
+		addr := listenerConfig.GetListenAddress()
+		go func() {
+			zap.S().Infof("Starting gateway on %s (TLS)", addr)
+			zap.S().Fatal(gateway.ListenAndServeTLS(addr, tlsConfig))
+		}()
+	}
+
+	zap.S().Infof("Starting gateway on %s", apiGwConfig.Server.GetListenAddress())
+	gateway.ListenAndServe(apiGwConfig.Server.GetListenAddress())
+}
+
+func newRoutes(routeConfigs []config.RouteConfig) ([]*proxy.Route, error) {
+	routes := make([]*proxy.Route, 0, len(routeConfigs))
+	for _, routeConfig := range routeConfigs {
+		r, err := newRoute(routeConfig)
+		if err != nil {
+			closeRoutes(routes)
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func newRoute(routeConfig config.RouteConfig) (*proxy.Route, error) {
+	targetConfigs := routeConfig.GetTargets()
+	if len(targetConfigs) == 0 {
+		return nil, fmt.Errorf("route '%s' has no backend targets", routeConfig.Path)
+	}
+
+	balancer, err := newBalancer(routeConfig.LoadBalancer)
+	if err != nil {
+		return nil, err
+	}
+
+	r := proxy.NewRoute().
+		WithMethods(routeConfig.Methods).
+		WithPath(routeConfig.Path).
+		WithBalancer(balancer).
+		WithPreserveHost(routeConfig.PreserveHost).
+		WithTimeouts(proxy.Timeouts{
+			Connect:        routeConfig.Timeouts.Connect,
+			ResponseHeader: routeConfig.Timeouts.ResponseHeader,
+			Total:          routeConfig.Timeouts.Total,
+		}).
+		WithConnectionPool(proxy.ConnectionPool{
+			MaxIdleConns:        routeConfig.Transport.MaxIdleConns,
+			MaxIdleConnsPerHost: routeConfig.Transport.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     routeConfig.Transport.MaxConnsPerHost,
+			IdleConnTimeout:     routeConfig.Transport.IdleConnTimeout,
+			KeepAlive:           routeConfig.Transport.KeepAlive,
+			DisableHTTP2:        routeConfig.Transport.DisableHTTP2,
+		})
+
+	if hc := routeConfig.HealthCheck; hc.Enabled() {
+		r.WithHealthCheck(proxy.NewHealthCheck(hc.Path).
+			WithInterval(hc.Interval).
+			WithTimeout(hc.Timeout).
+			WithExpectedStatus(hc.ExpectedStatus.Min, hc.ExpectedStatus.Max).
+			WithExpectedBody(hc.ExpectedBody).
+			WithThresholds(hc.HealthyThreshold, hc.UnhealthyThreshold))
+	}
+
+	if cb := routeConfig.CircuitBreaker; cb.Enabled {
+		r.WithCircuitBreaker(proxy.NewCircuitBreaker().
+			WithConsecutiveFailures(cb.ConsecutiveFailures).
+			WithErrorRate(cb.ErrorRate, cb.Window, cb.MinRequests).
+			WithLatencyThreshold(cb.LatencyThreshold).
+			WithOpenTimeout(cb.OpenTimeout).
+			WithHalfOpenRequests(cb.HalfOpenRequests).
+			WithFailFastResponse(cb.FailFastStatus, cb.FailFastBody))
+	}
+
+	if rc := routeConfig.Retry; rc.Enabled() {
+		retryPolicy, err := proxy.NewRetryPolicy(rc.MaxAttempts).
+			WithRetryOnStatus(rc.RetryOnStatus...).
+			WithBackoff(rc.BaseBackoff, rc.MaxBackoff).
+			WithBufferBodyLimit(rc.BufferBodyLimit).
+			WithRetryOnErrors(rc.RetryOnErrors...)
+		if err != nil {
+			return nil, err
+		}
+		r.WithRetryPolicy(retryPolicy)
+	}
+
+	if tc := routeConfig.TLS; tc.Enabled() {
+		upstreamTLS, err := proxy.NewUpstreamTLS().
+			WithServerName(tc.ServerName).
+			WithInsecureSkipVerify(tc.InsecureSkipVerify).
+			WithCA(tc.CAFile)
+		if err == nil {
+			upstreamTLS, err = upstreamTLS.WithClientCertificate(tc.CertFile, tc.KeyFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.WithUpstreamTLS(upstreamTLS)
+	}
+
+	for _, targetConfig := range targetConfigs {
+		url, err := targetConfig.GetUrl()
+		if err != nil {
+			return nil, err
+		}
+
+		pathRewrite, err := proxy.NewPathRewrite(routeConfig.Path, url.Path, routeConfig.StripPrefix, routeConfig.AddPrefix)
+		if err != nil {
+			return nil, err
+		}
+
+		target := proxy.NewTarget(url).
+			WithWeight(targetConfig.Weight).
+			WithPathRewrite(pathRewrite)
+		if cc := routeConfig.Concurrency; cc.Enabled() {
+			limiter := middleware.NewConcurrencyLimiter(middleware.ConcurrencyLimit{
+				MaxInFlight:  cc.MaxInFlight,
+				MaxQueue:     cc.MaxQueue,
+				QueueTimeout: cc.QueueTimeout,
+				RetryAfter:   cc.RetryAfter,
+			})
+			if err := withLimitAlgorithm(limiter, cc); err != nil {
+				return nil, fmt.Errorf("route '%s': %v", routeConfig.Path, err)
+			}
+			target.WithConcurrencyLimiter(limiter.WithMetricLabels(routeConfig.Path, url.Redacted()))
+		}
+		r.WithTargets(target)
+	}
+
+	filters, err := newFilters(routeConfig.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': %v", routeConfig.Path, err)
+	}
+	r.WithFilters(filters...)
+
+	return r, nil
+}
+
+func newConsumers(consumerConfigs []config.ConsumerConfig) (*middleware.ConsumerRegistry, error) {
+	registry := middleware.NewConsumerRegistry()
+	for _, consumerConfig := range consumerConfigs {
+		consumer := middleware.Consumer{Id: consumerConfig.Id, Groups: consumerConfig.Groups}
+		if _, err := registry.WithConsumer(consumer, consumerConfig.KeyHashes...); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// newFilters builds the middlewares of the filter configurations, closing
+// those already built if one fails.
+func newFilters(filterConfigs []config.FilterConfig) ([]middleware.Middleware, error) {
+	filters := make([]middleware.Middleware, 0, len(filterConfigs))
+	for _, filterConfig := range filterConfigs {
+		filter, err := middleware.New(filterConfig.Name, filterConfig.Params)
+		if err != nil {
+			middleware.Close(filters...)
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// checkRateLimitKeys rejects rate limits whose key is not known where they are
+// applied. Rate limits keyed by consumer must be applied after a key_auth
+// filter, as they would limit every request by its client IP. Global filters
+// are applied by their priority, key_auth before rate_limit, and before the
+// filters of the routes, which are applied in order. Global rate limits cannot
+// be keyed by route, as they are applied before the request is routed.
+func checkRateLimitKeys(globalFilters []config.FilterConfig, routeConfigs []config.RouteConfig) error {
+	globalKeyAuth := false
+	for _, filterConfig := range globalFilters {
+		if filterConfig.Name == middleware.KeyAuthMiddlewareName {
+			globalKeyAuth = true
+		}
+	}
+	for _, filterConfig := range globalFilters {
+		if isRateLimitKeyedBy(filterConfig, middleware.RateLimitKeyConsumer) && !globalKeyAuth {
+			return errors.New("global rate_limit keyed by consumer requires a global key_auth filter")
+		}
+		if isRateLimitKeyedBy(filterConfig, middleware.RateLimitKeyRoute) {
+			return errors.New("global rate_limit cannot be keyed by route, set it on the routes instead")
+		}
+	}
+
+	for _, routeConfig := range routeConfigs {
+		authenticated := globalKeyAuth
+		for _, filterConfig := range routeConfig.Filters {
+			if filterConfig.Name == middleware.KeyAuthMiddlewareName {
+				authenticated = true
+			}
+			if isRateLimitKeyedBy(filterConfig, middleware.RateLimitKeyConsumer) && !authenticated {
+				return fmt.Errorf("route '%s': rate_limit keyed by consumer must follow a key_auth filter", routeConfig.Path)
+			}
+		}
+	}
+	return nil
+}
+
+func isRateLimitKeyedBy(filterConfig config.FilterConfig, key string) bool {
+	return filterConfig.Name == middleware.RateLimitMiddlewareName && filterConfig.Params["key"] == key
+}
+
+// withLimitAlgorithm sets the adaptive limit algorithm of the configuration, if
+// any. Each target gets its own algorithm, as they keep the state of the
+// target.
+func withLimitAlgorithm(limiter *middleware.ConcurrencyLimiter, cfg config.ConcurrencyConfig) error {
+	a := cfg.Adaptive
+	switch a.Algorithm {
+	case "":
+	case middleware.LimitAlgorithmAIMD:
+		limiter.WithLimitAlgorithm(middleware.NewAIMDLimit(cfg.MaxInFlight, a.MinLimit, a.MaxLimit).
+			WithLatencyThreshold(a.LatencyThreshold).
+			WithBackoffRatio(a.BackoffRatio))
+	case middleware.LimitAlgorithmGradient:
+		limiter.WithLimitAlgorithm(middleware.NewGradientLimit(cfg.MaxInFlight, a.MinLimit, a.MaxLimit).
+			WithTolerance(a.Tolerance).
+			WithSmoothing(a.Smoothing))
+	default:
+		return fmt.Errorf("unknown adaptive concurrency algorithm '%s'", a.Algorithm)
+	}
+	return nil
+}
+
+// closeRoutes closes the filters of routes which are not put into service.
+func closeRoutes(routes []*proxy.Route) {
+	for _, r := range routes {
+		middleware.Close(r.Filters()...)
+	}
+}
+
+func newBalancer(cfg config.LoadBalancerConfig) (proxy.Balancer, error) {
+	switch cfg.Strategy {
+	case "", config.LoadBalancerRoundRobin:
+		return proxy.NewRoundRobinBalancer(), nil
+	case config.LoadBalancerWeightedRoundRobin:
+		return proxy.NewWeightedRoundRobinBalancer(), nil
+	case config.LoadBalancerLeastOutstanding:
+		return proxy.NewLeastOutstandingBalancer(), nil
+	case config.LoadBalancerRandomTwoChoices:
+		return proxy.NewRandomTwoChoicesBalancer(), nil
+	case config.LoadBalancerConsistentHash:
+		switch cfg.HashOn {
+		case config.HashOnHeader:
+			return proxy.NewConsistentHashBalancer(proxy.HeaderHashKey(cfg.HashKey)), nil
+		case config.HashOnCookie:
+			return proxy.NewConsistentHashBalancer(proxy.CookieHashKey(cfg.HashKey)), nil
+		case "", config.HashOnClientIP:
+			return proxy.NewConsistentHashBalancer(proxy.ClientIPHashKey()), nil
+		}
+		return nil, fmt.Errorf("unknown hash_on '%s' for consistent hash load balancer", cfg.HashOn)
+	}
+	return nil, fmt.Errorf("unknown load balancer strategy '%s'", cfg.Strategy)
+}
+
+func newTLSConfig(cfg config.TLSListenerConfig) (*proxy.CertificateStore, *proxy.TLSConfig, error) {
+	files := make([]proxy.CertificateFiles, 0, len(cfg.Certificates))
+	for _, c := range cfg.Certificates {
+		files = append(files, proxy.CertificateFiles{
+			CertFile:       c.CertFile,
+			KeyFile:        c.KeyFile,
+			OCSPStapleFile: c.OCSPStapleFile,
+		})
+	}
+
+	certificates, err := proxy.NewCertificateStore(files...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls listener %s: %v", cfg.GetListenAddress(), err)
+	}
+
+	minVersion, err := proxy.ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites, err := proxy.ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := proxy.NewTLSConfig(certificates).
+		WithMinVersion(minVersion).
+		WithCipherSuites(cipherSuites...).
+		WithNextProtos(cfg.ALPN...)
+	return certificates, tlsConfig, nil
+}
+
+func newAccessLog(cfg config.AccessLogConfig) (*middleware.AccessLog, error) {
+	var out io.Writer
+	switch cfg.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		rotation := cfg.Rotation
+		file, err := logfile.NewRotatingFile(cfg.Output, int64(rotation.MaxSizeMB)<<20, rotation.Interval, rotation.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+	return middleware.NewAccessLog(out).WithFormat(cfg.Format, cfg.Template)
+}
+
+func newTracer(cfg config.TracingConfig) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "", tracing.ExporterOTLPHTTP:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = "http://localhost:4318/v1/traces"
+		}
+		exporter = tracing.NewOTLPHTTPExporter(endpoint).WithHeaders(cfg.Headers)
+	case tracing.ExporterJSONLines:
+		jsonLines, err := tracing.NewJSONLinesExporter(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = jsonLines
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter '%s'", cfg.Exporter)
+	}
+
+	propagators := make([]tracing.Propagator, 0, len(cfg.Propagation))
+	for _, format := range cfg.Propagation {
+		propagator, err := tracing.NewPropagator(format)
+		if err != nil {
+			return nil, err
+		}
+		propagators = append(propagators, propagator)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "api-gw"
+	}
+	tracer := tracing.NewTracer(serviceName, exporter).WithPropagators(propagators...)
+	if cfg.SampleRatio != nil {
+		tracer.WithSampleRatio(*cfg.SampleRatio)
+	}
+	return tracer, nil
+}
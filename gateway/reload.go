@@ -0,0 +1,219 @@
+package gateway
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cdmatta/api-gw/config"
+	"github.com/cdmatta/api-gw/middleware"
+	"github.com/cdmatta/api-gw/proxy"
+	"go.uber.org/zap"
+)
+
+// configReloader reloads the routes of the gateway from the configuration file
+// on SIGHUP, and optionally whenever the file changes. A configuration that
+// fails to load or to build is rejected and the current routes are kept.
+// Only the routes and consumers are reloaded, the other sections, e.g. server
+// settings and global filters, are only applied on restart.
+//
+// Routes whose configuration did not change are carried over to the new
+// routes as they are, so that they keep their state, e.g. the health of their
+// targets, their circuit breakers and their rate limits.
+type configReloader struct {
+	configFile string
+	gateway    *proxy.ReverseProxy
+	current    atomic.Value
+
+	mu sync.Mutex
+	// routes are the routes built from the route configurations of the
+	// current configuration, in the same order.
+	routes []*proxy.Route
+}
+
+func newConfigReloader(configFile string, apiGwConfig *config.ApiGatewayConfig, routes []*proxy.Route, gateway *proxy.ReverseProxy) *configReloader {
+	c := &configReloader{
+		configFile: configFile,
+		gateway:    gateway,
+		routes:     routes,
+	}
+	c.current.Store(apiGwConfig)
+	return c
+}
+
+// currentConfig returns the configuration the gateway is running with.
+func (c *configReloader) currentConfig() *config.ApiGatewayConfig {
+	return c.current.Load().(*config.ApiGatewayConfig)
+}
+
+func (c *configReloader) reload(trigger string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	zap.S().Infof("reloading %s (%s)", c.configFile, trigger)
+
+	apiGwConfig, err := config.LoadConfig(c.configFile)
+	if err != nil {
+		zap.S().Errorf("rejected configuration %s: %v", c.configFile, err)
+		return err
+	}
+
+	consumers, err := newConsumers(apiGwConfig.Consumers)
+	if err == nil {
+		err = c.setRoutes(apiGwConfig.Routes)
+	}
+	if err != nil {
+		zap.S().Errorf("rejected configuration %s, keeping previous routes: %v", c.configFile, err)
+		return err
+	}
+	middleware.SetConsumers(consumers)
+
+	next := *c.currentConfig()
+	next.Consumers, next.Routes = apiGwConfig.Consumers, apiGwConfig.Routes
+	if sections := restartSections(&next, apiGwConfig); len(sections) > 0 {
+		zap.S().Warnf("changes of %v in %s are only applied on restart", sections, c.configFile)
+	}
+	c.current.Store(&next)
+
+	zap.S().Infof("reloaded %d routes from %s", len(apiGwConfig.Routes), c.configFile)
+	return nil
+}
+
+// setRoutes sets the routes of the route configurations on the gateway,
+// reusing the current routes of unchanged configurations.
+func (c *configReloader) setRoutes(routeConfigs []config.RouteConfig) error {
+	if err := checkRateLimitKeys(c.currentConfig().Filters, routeConfigs); err != nil {
+		return err
+	}
+
+	currentConfigs := c.currentConfig().Routes
+	reused := make([]bool, len(currentConfigs))
+
+	routes := make([]*proxy.Route, 0, len(routeConfigs))
+	var built []*proxy.Route
+	for _, routeConfig := range routeConfigs {
+		if i := indexOfRouteConfig(currentConfigs, reused, routeConfig); i >= 0 {
+			reused[i] = true
+			routes = append(routes, c.routes[i])
+			continue
+		}
+
+		r, err := newRoute(routeConfig)
+		if err != nil {
+			closeRoutes(built)
+			return err
+		}
+		built = append(built, r)
+		routes = append(routes, r)
+	}
+
+	if err := c.gateway.SetRoutes(routes); err != nil {
+		return err
+	}
+	c.routes = routes
+	zap.S().Debugf("rebuilt %d of %d routes", len(built), len(routes))
+	return nil
+}
+
+// restartSections returns the sections of the configuration that differ
+// between the configurations, by their name in the configuration file.
+func restartSections(current, loaded *config.ApiGatewayConfig) []string {
+	var sections []string
+	c, l := reflect.ValueOf(current).Elem(), reflect.ValueOf(loaded).Elem()
+	for i := 0; i < c.NumField(); i++ {
+		if !reflect.DeepEqual(c.Field(i).Interface(), l.Field(i).Interface()) {
+			sections = append(sections, c.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return sections
+}
+
+// indexOfRouteConfig returns the index of the configuration among the current
+// configurations not reused yet, or -1 if there is none.
+func indexOfRouteConfig(currentConfigs []config.RouteConfig, reused []bool, routeConfig config.RouteConfig) int {
+	for i := range currentConfigs {
+		if !reused[i] && reflect.DeepEqual(currentConfigs[i], routeConfig) {
+			return i
+		}
+	}
+	return -1
+}
+
+// RouteConfigs returns the configurations of the current routes.
+func (c *configReloader) RouteConfigs() []config.RouteConfig {
+	return c.currentConfig().Routes
+}
+
+// UpdateRouteConfigs replaces the routes of the gateway with the route
+// configurations returned by update, which is passed a copy of the current
+// route configurations. If admin.persist_file is set, the resulting
+// configuration is written to that file.
+func (c *configReloader) UpdateRouteConfigs(update func([]config.RouteConfig) ([]config.RouteConfig, error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.currentConfig()
+	routeConfigs, err := update(append([]config.RouteConfig(nil), current.Routes...))
+	if err != nil {
+		return err
+	}
+
+	if err := c.setRoutes(routeConfigs); err != nil {
+		return err
+	}
+
+	next := *current
+	next.Routes = routeConfigs
+	c.current.Store(&next)
+	zap.S().Infof("updated routes, now serving %d routes", len(routeConfigs))
+
+	if persistFile := next.Admin.PersistFile; persistFile != "" {
+		if err := config.SaveConfig(persistFile, &next); err != nil {
+			zap.S().Errorf("failed to persist routes to %s: %v", persistFile, err)
+			return fmt.Errorf("routes applied but not persisted: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *configReloader) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		_ = c.reload("SIGHUP")
+	}
+}
+
+// watchFile polls the modification time of the configuration file.
+func (c *configReloader) watchFile(interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	var modTime time.Time
+	if info, err := os.Stat(c.configFile); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(c.configFile)
+		if err != nil {
+			zap.S().Warnf("cannot watch %s: %v", c.configFile, err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		_ = c.reload("file changed")
+	}
+}
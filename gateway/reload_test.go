@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cdmatta/api-gw/config"
+	"github.com/cdmatta/api-gw/proxy"
+)
+
+func newTestRouteConfig(path, url string) config.RouteConfig {
+	return config.RouteConfig{
+		FrontendConfig: config.FrontendConfig{Methods: []string{"GET"}, Path: path},
+		BackendConfig:  config.BackendConfig{Url: url},
+	}
+}
+
+func TestConfigReloader_UpdateRouteConfigsKeepsUnchangedRoutes(t *testing.T) {
+	apiGwConfig := &config.ApiGatewayConfig{Routes: []config.RouteConfig{
+		newTestRouteConfig("/users", "http://users:8080/users"),
+		newTestRouteConfig("/orders", "http://orders:8080/orders"),
+	}}
+	routes, err := newRoutes(apiGwConfig.Routes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gateway := proxy.NewReverseProxy()
+	if err := gateway.SetRoutes(routes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloader := newConfigReloader("", apiGwConfig, routes, gateway)
+
+	err = reloader.UpdateRouteConfigs(func(routeConfigs []config.RouteConfig) ([]config.RouteConfig, error) {
+		routeConfigs[1] = newTestRouteConfig("/orders", "http://orders-v2:8080/orders")
+		return append(routeConfigs, newTestRouteConfig("/items", "http://items:8080/items")), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updated := gateway.Routes()
+	if len(updated) != 3 {
+		t.Fatalf("invalid number of routes, expected: 3, actual: %d", len(updated))
+	}
+	if updated[0] != routes[0] {
+		t.Error("unchanged route was rebuilt")
+	}
+	if updated[1] == routes[1] {
+		t.Error("changed route was not rebuilt")
+	}
+	if actual := updated[1].Targets()[0].URL().Host; actual != "orders-v2:8080" {
+		t.Errorf("invalid target of changed route, expected: 'orders-v2:8080', actual: '%s'", actual)
+	}
+}
+
+func TestCheckRateLimitKeys(t *testing.T) {
+	keyAuth := config.FilterConfig{Name: "key_auth"}
+	rateLimit := config.FilterConfig{Name: "rate_limit", Params: map[string]interface{}{"key": "consumer"}}
+	ipRateLimit := config.FilterConfig{Name: "rate_limit", Params: map[string]interface{}{"key": "ip"}}
+	routeRateLimit := config.FilterConfig{Name: "rate_limit", Params: map[string]interface{}{"key": "route"}}
+	route := func(filters ...config.FilterConfig) []config.RouteConfig {
+		routeConfig := newTestRouteConfig("/users", "http://users:8080/users")
+		routeConfig.Filters = filters
+		return []config.RouteConfig{routeConfig}
+	}
+
+	tests := []struct {
+		name          string
+		globalFilters []config.FilterConfig
+		routeConfigs  []config.RouteConfig
+		valid         bool
+	}{
+		{"global key_auth and rate_limit", []config.FilterConfig{rateLimit, keyAuth}, nil, true},
+		{"global rate_limit without key_auth", []config.FilterConfig{rateLimit}, route(keyAuth), false},
+		{"global key_auth, route rate_limit", []config.FilterConfig{keyAuth}, route(rateLimit), true},
+		{"route rate_limit after key_auth", nil, route(keyAuth, rateLimit), true},
+		{"route rate_limit before key_auth", nil, route(rateLimit, keyAuth), false},
+		{"route rate_limit by ip", nil, route(ipRateLimit), true},
+		{"global rate_limit by route", []config.FilterConfig{routeRateLimit}, nil, false},
+		{"route rate_limit by route", nil, route(routeRateLimit), true},
+	}
+	for _, test := range tests {
+		err := checkRateLimitKeys(test.globalFilters, test.routeConfigs)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%s: invalid result, expected valid: %v, actual: %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestConfigReloader_ReloadKeepsRestartSections(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "application.yml")
+	writeConfig := func(level, path string) {
+		data := "logging:\n  level: " + level + "\nroutes:\n  - frontend:\n      path: " + path +
+			"\n      methods: [GET]\n    backend:\n      url: http://users:8080/users\n"
+		if err := ioutil.WriteFile(configFile, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	writeConfig("info", "/users")
+	apiGwConfig, err := config.LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	routes, err := newRoutes(apiGwConfig.Routes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gateway := proxy.NewReverseProxy()
+	if err := gateway.SetRoutes(routes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloader := newConfigReloader(configFile, apiGwConfig, routes, gateway)
+
+	writeConfig("debug", "/accounts")
+	if err := reloader.reload("test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	current := reloader.currentConfig()
+	if actual := current.Routes[0].Path; actual != "/accounts" {
+		t.Errorf("invalid route path, expected: '/accounts', actual: '%s'", actual)
+	}
+	if actual := current.Logging.Level; actual != "info" {
+		t.Errorf("invalid logging level, expected: 'info', actual: '%s'", actual)
+	}
+
+	loaded, _ := config.LoadConfig(configFile)
+	if sections := restartSections(current, loaded); !reflect.DeepEqual(sections, []string{"logging"}) {
+		t.Errorf("invalid restart sections, expected: [logging], actual: %v", sections)
+	}
+}
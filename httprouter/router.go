@@ -76,7 +76,6 @@ import (
 	"context"
 	"net/http"
 	"strings"
-	"sync"
 )
 
 // Handle is a function that can be registered to a route to handle HTTP
@@ -134,9 +133,6 @@ func (ps Params) MatchedRoutePath() string {
 type Router struct {
 	trees map[string]*node
 
-	paramsPool sync.Pool
-	maxParams  uint16
-
 	// If enabled, adds the matched route path onto the http.Request context
 	// before invoking the handler.
 	// The matched route path is only added to handlers of routes that were
@@ -215,30 +211,35 @@ func New() *Router {
 	}
 }
 
-func (r *Router) getParams() *Params {
-	ps, _ := r.paramsPool.Get().(*Params)
-	*ps = (*ps)[0:0] // reset slice
-	return ps
+// handleAdapter adapts a Handle to the http.Handler stored in the tree nodes.
+type handleAdapter Handle
+
+func (h handleAdapter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h(w, req, ParamsFromContext(req.Context()))
 }
 
-func (r *Router) putParams(ps *Params) {
+// resolve looks up the handle registered for the path in the tree of the
+// given root node, converting the resolved path parameters into Params.
+func resolve(root *node, path string) (Handle, Params, bool) {
+	handler, ps, tsr := root.Resolve(path)
+	if handler == nil {
+		return nil, nil, tsr
+	}
+
+	var params Params
 	if ps != nil {
-		r.paramsPool.Put(ps)
+		params = make(Params, len(ps.parameters))
+		for i, p := range ps.parameters {
+			params[i] = Param{Key: p.Key, Value: p.Value}
+		}
 	}
+	return Handle(handler.(handleAdapter)), params, tsr
 }
 
 func (r *Router) saveMatchedRoutePath(path string, handle Handle) Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps Params) {
-		if ps == nil {
-			psp := r.getParams()
-			ps = (*psp)[0:1]
-			ps[0] = Param{Key: MatchedRoutePathParam, Value: path}
-			handle(w, req, ps)
-			r.putParams(psp)
-		} else {
-			ps = append(ps, Param{Key: MatchedRoutePathParam, Value: path})
-			handle(w, req, ps)
-		}
+		ps = append(ps, Param{Key: MatchedRoutePathParam, Value: path})
+		handle(w, req, ps)
 	}
 }
 
@@ -286,8 +287,6 @@ func (r *Router) DELETE(path string, handle Handle) {
 // frequently used, non-standardized or custom methods (e.g. for internal
 // communication with a proxy).
 func (r *Router) Handle(method, path string, handle Handle) {
-	varsCount := uint16(0)
-
 	if method == "" {
 		panic("method must not be empty")
 	}
@@ -299,7 +298,6 @@ func (r *Router) Handle(method, path string, handle Handle) {
 	}
 
 	if r.SaveMatchedRoutePath {
-		varsCount++
 		handle = r.saveMatchedRoutePath(path, handle)
 	}
 
@@ -315,20 +313,7 @@ func (r *Router) Handle(method, path string, handle Handle) {
 		r.globalAllowed = r.allowed("*", "")
 	}
 
-	root.addRoute(path, handle)
-
-	// Update maxParams
-	if paramsCount := countParams(path); paramsCount+varsCount > r.maxParams {
-		r.maxParams = paramsCount + varsCount
-	}
-
-	// Lazy-init paramsPool alloc func
-	if r.paramsPool.New == nil && r.maxParams > 0 {
-		r.paramsPool.New = func() interface{} {
-			ps := make(Params, 0, r.maxParams)
-			return &ps
-		}
-	}
+	root.AddRoute(path, handleAdapter(handle))
 }
 
 // Handler is an adapter which allows the usage of an http.Handler as a
@@ -389,15 +374,7 @@ func (r *Router) recv(w http.ResponseWriter, req *http.Request) {
 // the same path with an extra / without the trailing slash should be performed.
 func (r *Router) Lookup(method, path string) (Handle, Params, bool) {
 	if root := r.trees[method]; root != nil {
-		handle, ps, tsr := root.getValue(path, r.getParams)
-		if handle == nil {
-			r.putParams(ps)
-			return nil, nil, tsr
-		}
-		if ps == nil {
-			return handle, nil, tsr
-		}
-		return handle, *ps, tsr
+		return resolve(root, path)
 	}
 	return nil, nil, false
 }
@@ -425,8 +402,8 @@ func (r *Router) allowed(path, reqMethod string) (allow string) {
 				continue
 			}
 
-			handle, _, _ := r.trees[method].getValue(path, nil)
-			if handle != nil {
+			handler, _, _ := r.trees[method].Resolve(path)
+			if handler != nil {
 				// Add request method to list of allowed methods
 				allowed = append(allowed, method)
 			}
@@ -462,13 +439,8 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	path := req.URL.Path
 
 	if root := r.trees[req.Method]; root != nil {
-		if handle, ps, tsr := root.getValue(path, r.getParams); handle != nil {
-			if ps != nil {
-				handle(w, req, *ps)
-				r.putParams(ps)
-			} else {
-				handle(w, req, nil)
-			}
+		if handle, ps, tsr := resolve(root, path); handle != nil {
+			handle(w, req, ps)
 			return
 		} else if req.Method != http.MethodConnect && path != "/" {
 			// Moved Permanently, request with GET method
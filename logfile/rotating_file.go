@@ -0,0 +1,149 @@
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a log file which is rotated when it exceeds a maximum size,
+// or when the rotation interval elapsed. Rotated files are renamed with their
+// rotation time appended to the name, e.g. access.log.20201231T235959.000,
+// and the oldest are removed beyond the maximum number of backups.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+	rename   func(oldpath, newpath string) error
+}
+
+// NewRotatingFile opens the file for appending. Rotation by size and by time
+// are disabled by zero maxSize and interval, zero maxBackups keeps all backups.
+func NewRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		now:        time.Now,
+		rename:     os.Rename,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write writes p to the file, rotating the file first if due. If the rotation
+// fails, p is still written and the rotation error is returned.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rotateErr error
+	if f.rotationDue(int64(len(p))) {
+		rotateErr = f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// Sync flushes the file to disk, as needed by zap.
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Sync()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+func (f *RotatingFile) rotationDue(n int64) bool {
+	if f.maxSize > 0 && f.size > 0 && f.size+n > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && f.now().Sub(f.openedAt) >= f.interval
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size, f.openedAt = file, info.Size(), f.now()
+	return nil
+}
+
+// rotate renames the file to a backup and opens a new file. If either fails,
+// writing goes on to the current file and the rotation is retried when due
+// again.
+func (f *RotatingFile) rotate() error {
+	backup := f.path + "." + f.now().Format(backupTimeFormat)
+	if err := f.rename(f.path, backup); err != nil {
+		f.size, f.openedAt = 0, f.now()
+		return fmt.Errorf("failed to rotate %s: %v", f.path, err)
+	}
+
+	previous := f.file
+	if err := f.open(); err != nil {
+		f.size, f.openedAt = 0, f.now()
+		return fmt.Errorf("failed to rotate %s: %v", f.path, err)
+	}
+	previous.Close()
+	return f.removeBackups()
+}
+
+// removeBackups removes the oldest backups beyond the maximum number of
+// backups. Backup names sort in the order of their rotation time.
+func (f *RotatingFile) removeBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, backup := range backups {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(backup, f.path+".")); err == nil {
+			rotated = append(rotated, backup)
+		}
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > f.maxBackups {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
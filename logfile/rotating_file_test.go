@@ -0,0 +1,112 @@
+package logfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFile(t *testing.T, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, string) {
+	dir, err := ioutil.TempDir("", "logfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(path, maxSize, interval, maxBackups)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return f, dir
+}
+
+func backups(t *testing.T, f *RotatingFile) []string {
+	names, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRotatingFile_MaxSize(t *testing.T) {
+	f, dir := newTestFile(t, 10, 0, 2)
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	now := time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write([]byte("0123456\n")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if n := len(backups(t, f)); n != 2 {
+		t.Errorf("invalid number of backups, expected: %d, actual: %d", 2, n)
+	}
+	if data, _ := ioutil.ReadFile(f.path); string(data) != "0123456\n" {
+		t.Errorf("invalid current file: '%s'", data)
+	}
+}
+
+func TestRotatingFile_Interval(t *testing.T) {
+	f, dir := newTestFile(t, 0, time.Hour, 0)
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	_, _ = f.Write([]byte("first\n"))
+	now = now.Add(30 * time.Minute)
+	_, _ = f.Write([]byte("second\n"))
+	if n := len(backups(t, f)); n != 0 {
+		t.Fatalf("rotated before interval elapsed, backups: %d", n)
+	}
+
+	now = now.Add(30 * time.Minute)
+	_, _ = f.Write([]byte("third\n"))
+	if n := len(backups(t, f)); n != 1 {
+		t.Errorf("invalid number of backups, expected: %d, actual: %d", 1, n)
+	}
+}
+
+func TestRotatingFile_RenameFailure(t *testing.T) {
+	f, dir := newTestFile(t, 10, 0, 0)
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	renameErr := errors.New("rename failed")
+	f.rename = func(oldpath, newpath string) error {
+		return renameErr
+	}
+
+	if _, err := f.Write([]byte("0123456\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.Write([]byte("abcdefg\n")); err == nil {
+		t.Error("expected rotation error, none occurred")
+	}
+	f.rename = os.Rename
+	if _, err := f.Write([]byte("ABCDEFG\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := len(backups(t, f)); n != 1 {
+		t.Errorf("invalid number of backups, expected: %d, actual: %d", 1, n)
+	}
+	rotated, _ := ioutil.ReadFile(backups(t, f)[0])
+	if string(rotated) != "0123456\nabcdefg\n" {
+		t.Errorf("line lost on failed rotation, backup: '%s'", rotated)
+	}
+	if data, _ := ioutil.ReadFile(f.path); string(data) != "ABCDEFG\n" {
+		t.Errorf("invalid current file: '%s'", data)
+	}
+}
@@ -0,0 +1,235 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cdmatta/api-gw/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	EncodingConsole = "console"
+	EncodingJSON    = "json"
+)
+
+// NewLogger builds the application logger from the configuration. Without
+// configuration it logs at debug level to the console, as in development.
+func NewLogger(cfg config.LoggingConfig) (*zap.Logger, *Levels, error) {
+	var zapConfig zap.Config
+	switch cfg.Encoding {
+	case "", EncodingConsole:
+		zapConfig = zap.NewDevelopmentConfig()
+	case EncodingJSON:
+		zapConfig = zap.NewProductionConfig()
+	default:
+		return nil, nil, fmt.Errorf("unknown log encoding '%s'", cfg.Encoding)
+	}
+	zapConfig.EncoderConfig.TimeKey = "timestamp"
+	zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	zapConfig.EncoderConfig.CallerKey = ""
+
+	if len(cfg.OutputPaths) > 0 {
+		zapConfig.OutputPaths = cfg.OutputPaths
+	}
+	// The production config samples by default, entries are only dropped if
+	// sampling is configured.
+	zapConfig.Sampling = nil
+	if s := cfg.Sampling; s != nil {
+		zapConfig.Sampling = &zap.SamplingConfig{Initial: s.Initial, Thereafter: s.Thereafter}
+	}
+
+	levels := &Levels{
+		root:  zapConfig.Level,
+		named: map[string]zap.AtomicLevel{},
+	}
+	if cfg.Level != "" {
+		if err := levels.SetLevel("", cfg.Level); err != nil {
+			return nil, nil, err
+		}
+	}
+	for name, level := range cfg.Packages {
+		if err := levels.SetLevel(name, level); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The levels are applied by the core wrapping the built core, which
+	// therefore has to let all levels pass.
+	zapConfig.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	logger, err := zapConfig.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, levels: levels}
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return logger, levels, nil
+}
+
+// Named returns the logger of the package, whose level can be set apart from
+// the level of the gateway, see Levels.
+func Named(pkg string) *zap.SugaredLogger {
+	return zap.S().Named(pkg)
+}
+
+// Levels are the log level of the gateway and the levels of named loggers,
+// e.g. the logger of the proxy package, which can be changed at runtime.
+type Levels struct {
+	root zap.AtomicLevel
+
+	mu    sync.RWMutex
+	named map[string]zap.AtomicLevel
+}
+
+// SetLevel sets the level of the named logger, and of the loggers named
+// below it. The empty name sets the level of the gateway.
+func (l *Levels) SetLevel(name, level string) error {
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	if name == "" {
+		l.root.SetLevel(zapLevel)
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if atomicLevel, ok := l.named[name]; ok {
+		atomicLevel.SetLevel(zapLevel)
+	} else {
+		l.named[name] = zap.NewAtomicLevelAt(zapLevel)
+	}
+	return nil
+}
+
+// ResetLevel makes the named logger log at the level of the gateway again.
+func (l *Levels) ResetLevel(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.named, name)
+}
+
+// level returns the level of the logger, which is the level set for the
+// longest prefix of its name, or the level of the gateway.
+func (l *Levels) level(name string) zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for name != "" {
+		if atomicLevel, ok := l.named[name]; ok {
+			return atomicLevel.Level()
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return l.root.Level()
+}
+
+// enabled reports whether any logger logs at the level.
+func (l *Levels) enabled(level zapcore.Level) bool {
+	if l.root.Enabled(level) {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, atomicLevel := range l.named {
+		if atomicLevel.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+type levelsResponse struct {
+	Level   string            `json:"level"`
+	Loggers map[string]string `json:"loggers"`
+}
+
+type levelRequest struct {
+	Logger string `json:"logger"`
+	Level  string `json:"level"`
+}
+
+// ServeHTTP shows the levels on GET. PUT sets the level of the gateway, or of
+// a named logger, e.g. {"logger": "proxy", "level": "debug"}. DELETE with a
+// logger query parameter resets the level of the named logger.
+func (l *Levels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req levelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := l.SetLevel(req.Logger, req.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		zap.S().Infof("set log level of '%s' to %s", req.Logger, req.Level)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("logger")
+		if name == "" {
+			http.Error(w, "missing logger query parameter", http.StatusBadRequest)
+			return
+		}
+		l.ResetLevel(name)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l.snapshot())
+}
+
+func (l *Levels) snapshot() levelsResponse {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	names := make([]string, 0, len(l.named))
+	for name := range l.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resp := levelsResponse{Level: l.root.Level().String(), Loggers: map[string]string{}}
+	for _, name := range names {
+		resp.Loggers[name] = l.named[name].Level().String()
+	}
+	return resp
+}
+
+// levelCore filters the entries of the wrapped core by the level of the logger
+// the entries are logged with.
+type levelCore struct {
+	zapcore.Core
+	levels *Levels
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.levels.enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < c.levels.level(entry.LoggerName) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdmatta/api-gw/config"
+)
+
+func newTestLogger(t *testing.T, cfg config.LoggingConfig) (*Levels, func(name, msg string), func() string) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "gateway.log")
+	cfg.OutputPaths = []string{path}
+	logger, levels, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	debug := func(name, msg string) {
+		logger.Named(name).Debug(msg)
+	}
+	output := func() string {
+		_ = logger.Sync()
+		data, _ := ioutil.ReadFile(path)
+		return string(data)
+	}
+	return levels, debug, output
+}
+
+func TestNewLogger_PackageLevels(t *testing.T) {
+	_, debug, output := newTestLogger(t, config.LoggingConfig{
+		Level:    "info",
+		Encoding: EncodingJSON,
+		Packages: map[string]string{"proxy": "debug"},
+	})
+
+	debug("proxy", "proxy message")
+	debug("proxy.health", "health message")
+	debug("middleware", "middleware message")
+
+	out := output()
+	for _, msg := range []string{"proxy message", "health message"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("expected '%s' to be logged, output: %s", msg, out)
+		}
+	}
+	if strings.Contains(out, "middleware message") {
+		t.Errorf("expected debug message of middleware to be filtered, output: %s", out)
+	}
+}
+
+func TestNewLogger_NoSamplingByDefault(t *testing.T) {
+	_, debug, output := newTestLogger(t, config.LoggingConfig{Level: "debug", Encoding: EncodingJSON})
+
+	for i := 0; i < 150; i++ {
+		debug("proxy", "repeated message")
+	}
+
+	if n := strings.Count(output(), "repeated message"); n != 150 {
+		t.Errorf("invalid number of logged entries, expected: 150, actual: %d", n)
+	}
+}
+
+func TestNewLogger_Invalid(t *testing.T) {
+	tests := []config.LoggingConfig{
+		{Encoding: "xml"},
+		{Level: "verbose"},
+		{Packages: map[string]string{"proxy": "verbose"}},
+	}
+
+	for _, tt := range tests {
+		if _, _, err := NewLogger(tt); err == nil {
+			t.Errorf("expected error for %+v, none occurred", tt)
+		}
+	}
+}
+
+func TestLevels_ServeHTTP(t *testing.T) {
+	levels, debug, output := newTestLogger(t, config.LoggingConfig{Level: "info"})
+
+	tests := []struct {
+		method   string
+		target   string
+		body     string
+		status   int
+		expected string
+	}{
+		{http.MethodPut, "/admin/logging", `{"logger": "proxy", "level": "debug"}`, http.StatusOK, `{"level":"info","loggers":{"proxy":"debug"}}`},
+		{http.MethodGet, "/admin/logging", "", http.StatusOK, `{"level":"info","loggers":{"proxy":"debug"}}`},
+		{http.MethodPut, "/admin/logging", `{"level": "warn"}`, http.StatusOK, `{"level":"warn","loggers":{"proxy":"debug"}}`},
+		{http.MethodPut, "/admin/logging", `{"level": "verbose"}`, http.StatusBadRequest, ""},
+		{http.MethodPost, "/admin/logging", "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		levels.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: invalid status, expected: %d, actual: %d", tt.method, tt.body, tt.status, w.Code)
+		}
+		if body := strings.TrimSpace(w.Body.String()); tt.expected != "" && body != tt.expected {
+			t.Errorf("%s %s: invalid body, expected: '%s', actual: '%s'", tt.method, tt.body, tt.expected, body)
+		}
+	}
+
+	debug("proxy", "before reset")
+	w := httptest.NewRecorder()
+	levels.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/logging?logger=proxy", nil))
+	debug("proxy", "after reset")
+
+	if out := output(); !strings.Contains(out, "before reset") || strings.Contains(out, "after reset") {
+		t.Errorf("invalid output after changing levels: %s", out)
+	}
+}
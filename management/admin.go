@@ -0,0 +1,214 @@
+package management
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/cdmatta/api-gw/config"
+	"gopkg.in/yaml.v2"
+)
+
+const maxRouteConfigSize = 1 << 20
+
+var (
+	errRouteNotFound  = errors.New("route not found")
+	errRouteExists    = errors.New("route already exists")
+	errRouteAmbiguous = errors.New("several routes have the path, select one with the query parameter 'method'")
+)
+
+// RouteStore holds the route configurations the gateway is serving.
+type RouteStore interface {
+	RouteConfigs() []config.RouteConfig
+
+	// UpdateRouteConfigs atomically replaces the routes with the route
+	// configurations returned by update, if they are valid.
+	UpdateRouteConfigs(update func([]config.RouteConfig) ([]config.RouteConfig, error)) error
+}
+
+// AdminRoutesHandler serves the runtime management of routes:
+//
+//	GET    /admin/routes              lists all routes
+//	GET    /admin/routes?path=<path>  returns the route with the frontend path
+//	POST   /admin/routes              adds a route
+//	PUT    /admin/routes?path=<path>  replaces the route with the frontend path
+//	DELETE /admin/routes?path=<path>  removes the route with the frontend path
+//
+// Routes are identified by their frontend path and methods, routes with the
+// same path must not share a method. If several routes have the path, the
+// route is selected by one of its methods with the query parameter method,
+// e.g. ?path=/users&method=POST.
+//
+// Routes are written using the schema of the routes in the configuration file,
+// in YAML. They are read in YAML or JSON, which is valid YAML. Secrets are
+// redacted in the routes written, routes read with redacted values are
+// rejected, as the secrets they replaced are not known.
+type AdminRoutesHandler struct {
+	store RouteStore
+}
+
+func NewAdminRoutesHandler(store RouteStore) *AdminRoutesHandler {
+	return &AdminRoutesHandler{store: store}
+}
+
+func (a *AdminRoutesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path, method := req.URL.Query().Get("path"), req.URL.Query().Get("method")
+
+	switch req.Method {
+	case http.MethodGet:
+		a.get(w, path, method)
+	case http.MethodPost:
+		a.post(w, req)
+	case http.MethodPut:
+		a.put(w, req, path, method)
+	case http.MethodDelete:
+		a.delete(w, path, method)
+	default:
+		w.Header().Set("Allow", "DELETE, GET, POST, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *AdminRoutesHandler) get(w http.ResponseWriter, path, method string) {
+	routeConfigs := config.Redact(&config.ApiGatewayConfig{Routes: a.store.RouteConfigs()}).Routes
+	if path == "" {
+		writeYAML(w, http.StatusOK, routeConfigs)
+		return
+	}
+
+	i, err := indexOfRoute(routeConfigs, path, method)
+	if err != nil {
+		a.respond(w, err, http.StatusOK)
+		return
+	}
+	writeYAML(w, http.StatusOK, routeConfigs[i])
+}
+
+func (a *AdminRoutesHandler) post(w http.ResponseWriter, req *http.Request) {
+	routeConfig, err := readRouteConfig(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.store.UpdateRouteConfigs(func(routeConfigs []config.RouteConfig) ([]config.RouteConfig, error) {
+		for _, method := range routeConfig.Methods {
+			if _, err := indexOfRoute(routeConfigs, routeConfig.Path, method); err == nil {
+				return nil, errRouteExists
+			}
+		}
+		return append(routeConfigs, routeConfig), nil
+	})
+	a.respond(w, err, http.StatusCreated)
+}
+
+func (a *AdminRoutesHandler) put(w http.ResponseWriter, req *http.Request, path, method string) {
+	routeConfig, err := readRouteConfig(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if path == "" {
+		path = routeConfig.Path
+	}
+
+	err = a.store.UpdateRouteConfigs(func(routeConfigs []config.RouteConfig) ([]config.RouteConfig, error) {
+		i, err := indexOfRoute(routeConfigs, path, method)
+		if err != nil {
+			return nil, err
+		}
+		routeConfigs[i] = routeConfig
+		return routeConfigs, nil
+	})
+	a.respond(w, err, http.StatusOK)
+}
+
+func (a *AdminRoutesHandler) delete(w http.ResponseWriter, path, method string) {
+	if path == "" {
+		http.Error(w, "missing query parameter 'path'", http.StatusBadRequest)
+		return
+	}
+
+	err := a.store.UpdateRouteConfigs(func(routeConfigs []config.RouteConfig) ([]config.RouteConfig, error) {
+		i, err := indexOfRoute(routeConfigs, path, method)
+		if err != nil {
+			return nil, err
+		}
+		return append(routeConfigs[:i], routeConfigs[i+1:]...), nil
+	})
+	a.respond(w, err, http.StatusNoContent)
+}
+
+func (a *AdminRoutesHandler) respond(w http.ResponseWriter, err error, successStatus int) {
+	switch {
+	case err == nil:
+		w.WriteHeader(successStatus)
+	case errors.Is(err, errRouteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errRouteExists), errors.Is(err, errRouteAmbiguous):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func readRouteConfig(body io.Reader) (config.RouteConfig, error) {
+	var routeConfig config.RouteConfig
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxRouteConfigSize))
+	if err != nil {
+		return routeConfig, err
+	}
+	if err := yaml.UnmarshalStrict(data, &routeConfig); err != nil {
+		return routeConfig, fmt.Errorf("invalid route: %v", err)
+	}
+	if routeConfig.Path == "" {
+		return routeConfig, errors.New("invalid route: missing frontend path")
+	}
+	if config.IsRedacted(&config.ApiGatewayConfig{Routes: []config.RouteConfig{routeConfig}}) {
+		return routeConfig, errors.New("invalid route: redacted values, e.g. passwords, must be replaced by the actual values")
+	}
+	return routeConfig, nil
+}
+
+// indexOfRoute returns the index of the route with the path and, unless empty,
+// the method.
+func indexOfRoute(routeConfigs []config.RouteConfig, path, method string) (int, error) {
+	index := -1
+	for i := range routeConfigs {
+		if routeConfigs[i].Path != path || method != "" && !hasMethod(routeConfigs[i].Methods, method) {
+			continue
+		}
+		if index >= 0 {
+			return -1, errRouteAmbiguous
+		}
+		index = i
+	}
+	if index < 0 {
+		return -1, errRouteNotFound
+	}
+	return index, nil
+}
+
+func hasMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeYAML(w http.ResponseWriter, status int, v interface{}) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(status)
+	w.Write(data)
+}
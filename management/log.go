@@ -0,0 +1,10 @@
+package management
+
+import (
+	"github.com/cdmatta/api-gw/logging"
+	"go.uber.org/zap"
+)
+
+func logger() *zap.SugaredLogger {
+	return logging.Named("management")
+}
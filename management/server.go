@@ -0,0 +1,138 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/cdmatta/api-gw/config"
+	"github.com/cdmatta/api-gw/proxy"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// VersionInfo describes the build of the running gateway.
+type VersionInfo struct {
+	GitBranch  string `json:"gitBranch"`
+	GitSummary string `json:"gitSummary"`
+	Version    string `json:"version"`
+	BuildDate  string `json:"buildDate"`
+}
+
+// Server serves the management endpoints of the gateway on a listener
+// separate from the proxied traffic.
+type Server struct {
+	mux     *http.ServeMux
+	gateway *proxy.ReverseProxy
+	version VersionInfo
+	config  func() *config.ApiGatewayConfig
+	ready   int32
+}
+
+func NewServer(gateway *proxy.ReverseProxy, version VersionInfo, currentConfig func() *config.ApiGatewayConfig) *Server {
+	s := &Server{
+		mux:     http.NewServeMux(),
+		gateway: gateway,
+		version: version,
+		config:  currentConfig,
+	}
+
+	s.mux.Handle("/metrics", promhttp.Handler())
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/readyz", s.readyz)
+	s.mux.HandleFunc("/version", s.versionInfo)
+	s.mux.HandleFunc("/config", s.currentConfig)
+	s.mux.HandleFunc("/routes", s.routes)
+
+	return s
+}
+
+// Handle registers an additional management endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// SetReady sets whether the gateway is ready to accept traffic.
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (s *Server) readyz(w http.ResponseWriter, _ *http.Request) {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+func (s *Server) versionInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.version)
+}
+
+func (s *Server) currentConfig(w http.ResponseWriter, _ *http.Request) {
+	writeYAML(w, http.StatusOK, config.Redact(s.config()))
+}
+
+type targetStatus struct {
+	Url            string `json:"url"`
+	Weight         int    `json:"weight"`
+	Healthy        bool   `json:"healthy"`
+	Outstanding    int64  `json:"outstanding"`
+	CircuitBreaker string `json:"circuitBreaker,omitempty"`
+}
+
+type routeStatus struct {
+	Methods []string       `json:"methods"`
+	Path    string         `json:"path"`
+	Targets []targetStatus `json:"targets"`
+}
+
+func (s *Server) routes(w http.ResponseWriter, _ *http.Request) {
+	routes := s.gateway.Routes()
+
+	statuses := make([]routeStatus, 0, len(routes))
+	for _, route := range routes {
+		status := routeStatus{
+			Methods: route.Methods(),
+			Path:    route.Path(),
+		}
+		for _, target := range route.Targets() {
+			ts := targetStatus{
+				Url:         target.URL().Redacted(),
+				Weight:      target.Weight(),
+				Healthy:     target.Healthy(),
+				Outstanding: target.Outstanding(),
+			}
+			if cb := target.CircuitBreaker(); cb != nil {
+				ts.CircuitBreaker = cb.State().String()
+			}
+			status.Targets = append(status.Targets, ts)
+		}
+		statuses = append(statuses, status)
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger().Warnf("failed to write management response: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AccessLogFormatJSON     = "json"
+	AccessLogFormatCombined = "combined"
+	AccessLogFormatTemplate = "template"
+)
+
+// AccessLogEntry describes a request served by the gateway.
+type AccessLogEntry struct {
+	Time            time.Time
+	RemoteAddr      string
+	Method          string
+	URI             string
+	Route           string
+	Proto           string
+	Status          int
+	BytesSent       int64
+	BytesReceived   int64
+	Referer         string
+	UserAgent       string
+	Duration        time.Duration
+	RequestId       string
+	Upstream        string
+	UpstreamLatency time.Duration
+	Consumer        string
+}
+
+// accessLogVariables are the variables of access log templates, e.g.
+// "$remote_addr $method $uri $status ${upstream_latency_ms}ms".
+var accessLogVariables = map[string]func(e *AccessLogEntry) string{
+	"time":                func(e *AccessLogEntry) string { return e.Time.Format(time.RFC3339) },
+	"remote_addr":         func(e *AccessLogEntry) string { return e.RemoteAddr },
+	"method":              func(e *AccessLogEntry) string { return e.Method },
+	"uri":                 func(e *AccessLogEntry) string { return e.URI },
+	"route":               func(e *AccessLogEntry) string { return e.Route },
+	"proto":               func(e *AccessLogEntry) string { return e.Proto },
+	"status":              func(e *AccessLogEntry) string { return strconv.Itoa(e.Status) },
+	"bytes_sent":          func(e *AccessLogEntry) string { return strconv.FormatInt(e.BytesSent, 10) },
+	"bytes_received":      func(e *AccessLogEntry) string { return strconv.FormatInt(e.BytesReceived, 10) },
+	"referer":             func(e *AccessLogEntry) string { return e.Referer },
+	"user_agent":          func(e *AccessLogEntry) string { return e.UserAgent },
+	"duration_ms":         func(e *AccessLogEntry) string { return milliseconds(e.Duration) },
+	"request_id":          func(e *AccessLogEntry) string { return e.RequestId },
+	"upstream_addr":       func(e *AccessLogEntry) string { return e.Upstream },
+	"upstream_latency_ms": func(e *AccessLogEntry) string { return milliseconds(e.UpstreamLatency) },
+	"consumer":            func(e *AccessLogEntry) string { return e.Consumer },
+}
+
+// AccessLog writes one line per request to its output, separate from the
+// application log. The default format is JSON.
+type AccessLog struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format func(e *AccessLogEntry) []byte
+}
+
+func NewAccessLog(out io.Writer) *AccessLog {
+	return &AccessLog{
+		out:    out,
+		format: formatJSON,
+	}
+}
+
+// WithFormat sets the format of the access log, the template is only used by
+// the template format.
+func (l *AccessLog) WithFormat(format, template string) (*AccessLog, error) {
+	switch format {
+	case "", AccessLogFormatJSON:
+		l.format = formatJSON
+	case AccessLogFormatCombined:
+		l.format = formatCombined
+	case AccessLogFormatTemplate:
+		formatter, err := newTemplateFormatter(template)
+		if err != nil {
+			return nil, err
+		}
+		l.format = formatter
+	default:
+		return nil, fmt.Errorf("unknown access log format '%s'", format)
+	}
+	return l, nil
+}
+
+func (l *AccessLog) log(e *AccessLogEntry) {
+	line := l.format(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(line)
+}
+
+type jsonAccessLogEntry struct {
+	Time              string  `json:"time"`
+	RemoteAddr        string  `json:"remote_addr"`
+	Method            string  `json:"method"`
+	URI               string  `json:"uri"`
+	Route             string  `json:"route"`
+	Proto             string  `json:"proto"`
+	Status            int     `json:"status"`
+	BytesSent         int64   `json:"bytes_sent"`
+	BytesReceived     int64   `json:"bytes_received"`
+	Referer           string  `json:"referer,omitempty"`
+	UserAgent         string  `json:"user_agent,omitempty"`
+	DurationMs        float64 `json:"duration_ms"`
+	RequestId         string  `json:"request_id,omitempty"`
+	Upstream          string  `json:"upstream_addr,omitempty"`
+	UpstreamLatencyMs float64 `json:"upstream_latency_ms,omitempty"`
+	Consumer          string  `json:"consumer,omitempty"`
+}
+
+func formatJSON(e *AccessLogEntry) []byte {
+	line, _ := json.Marshal(jsonAccessLogEntry{
+		Time:              e.Time.Format(time.RFC3339Nano),
+		RemoteAddr:        e.RemoteAddr,
+		Method:            e.Method,
+		URI:               e.URI,
+		Route:             e.Route,
+		Proto:             e.Proto,
+		Status:            e.Status,
+		BytesSent:         e.BytesSent,
+		BytesReceived:     e.BytesReceived,
+		Referer:           e.Referer,
+		UserAgent:         e.UserAgent,
+		DurationMs:        float64(e.Duration.Microseconds()) / 1000,
+		RequestId:         e.RequestId,
+		Upstream:          e.Upstream,
+		UpstreamLatencyMs: float64(e.UpstreamLatency.Microseconds()) / 1000,
+		Consumer:          e.Consumer,
+	})
+	return append(line, '\n')
+}
+
+// formatCombined formats the entry in the Apache combined log format, with the
+// consumer as the user.
+func formatCombined(e *AccessLogEntry) []byte {
+	host := e.RemoteAddr
+	if i := strings.LastIndexByte(host, ':'); i > 0 {
+		host = host[:i]
+	}
+	user := "-"
+	if e.Consumer != "" {
+		user = e.Consumer
+	}
+	bytesSent := "-"
+	if e.BytesSent > 0 {
+		bytesSent = strconv.FormatInt(e.BytesSent, 10)
+	}
+
+	return []byte(fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s %q %q\n",
+		host, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.URI, e.Proto,
+		e.Status, bytesSent, e.Referer, e.UserAgent))
+}
+
+func newTemplateFormatter(template string) (func(e *AccessLogEntry) []byte, error) {
+	if template == "" {
+		return nil, fmt.Errorf("access log template is empty")
+	}
+
+	var unknown []string
+	os.Expand(template, func(name string) string {
+		if _, ok := accessLogVariables[name]; !ok {
+			unknown = append(unknown, name)
+		}
+		return ""
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown access log template variables %v", unknown)
+	}
+
+	return func(e *AccessLogEntry) []byte {
+		return []byte(os.Expand(template, func(name string) string {
+			return accessLogVariables[name](e)
+		}) + "\n")
+	}, nil
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64)
+}
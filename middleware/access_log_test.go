@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAccessLogEntry() *AccessLogEntry {
+	return &AccessLogEntry{
+		Time:            time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC),
+		RemoteAddr:      "192.0.2.1:4711",
+		Method:          http.MethodGet,
+		URI:             "/users/42?verbose=true",
+		Route:           "/users/:id",
+		Proto:           "HTTP/1.1",
+		Status:          http.StatusOK,
+		BytesSent:       512,
+		Referer:         "https://example.com/",
+		UserAgent:       "curl/7.68.0",
+		Duration:        12500 * time.Microsecond,
+		RequestId:       "req-1",
+		Upstream:        "localhost:8080",
+		UpstreamLatency: 10 * time.Millisecond,
+	}
+}
+
+func TestAccessLog_Formats(t *testing.T) {
+	tests := []struct {
+		format   string
+		template string
+		expected string
+	}{
+		{
+			format:   AccessLogFormatCombined,
+			expected: `192.0.2.1 - - [31/Dec/2020:23:59:59 +0000] "GET /users/42?verbose=true HTTP/1.1" 200 512 "https://example.com/" "curl/7.68.0"` + "\n",
+		},
+		{
+			format:   AccessLogFormatTemplate,
+			template: "$method $route $status ${duration_ms}ms $upstream_addr ${upstream_latency_ms}ms $request_id",
+			expected: "GET /users/:id 200 12.5ms localhost:8080 10ms req-1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		accessLog, err := NewAccessLog(&out).WithFormat(tt.format, tt.template)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		accessLog.log(newTestAccessLogEntry())
+
+		if out.String() != tt.expected {
+			t.Errorf("invalid %s line, expected: '%s', actual: '%s'", tt.format, tt.expected, out.String())
+		}
+	}
+}
+
+func TestAccessLog_JSON(t *testing.T) {
+	var out bytes.Buffer
+	NewAccessLog(&out).log(newTestAccessLogEntry())
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("invalid json line: %v", err)
+	}
+	if line["route"] != "/users/:id" || line["status"] != float64(200) || line["upstream_latency_ms"] != float64(10) {
+		t.Errorf("invalid json line: %s", out.String())
+	}
+}
+
+func TestAccessLog_InvalidFormat(t *testing.T) {
+	if _, err := NewAccessLog(nil).WithFormat("xml", ""); err == nil {
+		t.Error("expected error for unknown format, none occurred")
+	}
+	if _, err := NewAccessLog(nil).WithFormat(AccessLogFormatTemplate, "$method $unknown"); err == nil {
+		t.Error("expected error for unknown template variable, none occurred")
+	}
+}
+
+func TestAccessLoggingMetricsMiddleware_AccessLog(t *testing.T) {
+	var out bytes.Buffer
+	accessLog, _ := NewAccessLog(&out).WithFormat(AccessLogFormatTemplate, "$route $status $bytes_received $bytes_sent $upstream_addr")
+	handler := NewAccessLoggingMetricsMiddleware().WithAccessLog(accessLog).FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+		SetRouteTemplate(r, "/echo")
+		SetUpstream(r, "localhost:8080", time.Millisecond)
+		body := new(bytes.Buffer)
+		_, _ = body.ReadFrom(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body.Bytes())
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("payload")))
+
+	if expected := "/echo 201 7 7 localhost:8080\n"; out.String() != expected {
+		t.Errorf("invalid access log line, expected: '%s', actual: '%s'", expected, out.String())
+	}
+}
@@ -1,61 +1,144 @@
 package middleware
 
 import (
+	"io"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"go.uber.org/zap"
 )
 
-type AccessLoggingMetricsMiddleware struct{}
+const AccessLoggingMetricsMiddlewareName = "access_log"
 
-var gatewayRequestsDuration = promauto.NewHistogramVec(
-	prometheus.HistogramOpts{Name: "gateway_requests_seconds"},
-	[]string{"method", "status", "uri"},
+// AccessLoggingMetricsMiddleware records the request metrics and logs every
+// request, to the access log if one is set and otherwise to the application
+// log.
+type AccessLoggingMetricsMiddleware struct {
+	accessLog *AccessLog
+}
+
+var (
+	gatewayRequestsDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "gateway_requests_seconds"},
+		[]string{"method", "status", "uri"},
+	)
+	gatewayRequestsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "gateway_requests_in_flight"},
+	)
+	gatewayConsumerRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{Name: "gateway_consumer_requests_total"},
+		[]string{"consumer", "status", "uri"},
+	)
 )
 
 func NewAccessLoggingMetricsMiddleware() *AccessLoggingMetricsMiddleware {
 	return &AccessLoggingMetricsMiddleware{}
 }
 
-func (a *AccessLoggingMetricsMiddleware) getPriority() int {
+func (a *AccessLoggingMetricsMiddleware) WithAccessLog(accessLog *AccessLog) *AccessLoggingMetricsMiddleware {
+	a.accessLog = accessLog
+	return a
+}
+
+func (a *AccessLoggingMetricsMiddleware) Name() string {
+	return AccessLoggingMetricsMiddlewareName
+}
+
+func (a *AccessLoggingMetricsMiddleware) Priority() int {
 	return PriorityAccessLoggingMetricsMiddleware
 }
 
 func (a *AccessLoggingMetricsMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		remoteAddress := r.RemoteAddr
-		method := r.Method
-		uri := r.RequestURI // TODO replace with route path template ?
-		protocol := r.Proto
-		referer := r.Referer()
-		userAgent := r.UserAgent()
+		gatewayRequestsInFlight.Inc()
+		defer gatewayRequestsInFlight.Dec()
+
+		entry := &AccessLogEntry{
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			Time:       time.Now(),
+		}
 		lrw := newLoggingResponseWriter(w)
-		statusCode := http.StatusOK
-		start := time.Now()
+		body := &countingReadCloser{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
 
+		r, ri := withRequestInfo(r)
 		next.ServeHTTP(lrw, r)
 
-		statusCode = lrw.statusCode
-		duration := time.Since(start)
-		gatewayRequestsDuration.WithLabelValues(method, strconv.Itoa(statusCode), uri).Observe(duration.Seconds())
-		zap.S().Infof("%s %s %s %s %d '%s' '%s' %d", remoteAddress, method, uri, protocol, statusCode, referer, userAgent, duration.Milliseconds())
+		entry.Duration = time.Since(entry.Time)
+		entry.Status = lrw.statusCode
+		entry.BytesSent = lrw.bytesWritten
+		entry.BytesReceived = body.bytesRead
+		entry.Route = ri.route
+		entry.Upstream = ri.upstream
+		entry.UpstreamLatency = ri.upstreamLatency
+		entry.RequestId = RequestIdFromContext(r.Context())
+		entry.Consumer = ri.consumer
+		if ri.uri != "" {
+			entry.URI = ri.uri
+		}
+
+		status := strconv.Itoa(entry.Status)
+		gatewayRequestsDuration.WithLabelValues(entry.Method, status, entry.Route).Observe(entry.Duration.Seconds())
+		if entry.Consumer != "" {
+			gatewayConsumerRequests.WithLabelValues(entry.Consumer, status, entry.Route).Inc()
+		}
+		if a.accessLog != nil {
+			a.accessLog.log(entry)
+			return
+		}
+
+		logger := logger()
+		if entry.RequestId != "" {
+			logger = logger.With("request_id", entry.RequestId)
+		}
+		logger.Infof("%s %s %s %s %s %d '%s' '%s' %d", entry.RemoteAddr, entry.Method, entry.URI, entry.Route, entry.Proto,
+			entry.Status, entry.Referer, entry.UserAgent, entry.Duration.Milliseconds())
 	}
 }
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64
 }
 
 func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
-	return &loggingResponseWriter{w, http.StatusOK}
+	return &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 }
 
 func (l *loggingResponseWriter) WriteHeader(code int) {
 	l.statusCode = code
 	l.ResponseWriter.WriteHeader(code)
 }
+
+func (l *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := l.ResponseWriter.Write(b)
+	l.bytesWritten += int64(n)
+	return n, err
+}
+
+func (l *loggingResponseWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	bytesRead int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.bytesRead += int64(n)
+	return n, err
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	LimitAlgorithmAIMD     = "aimd"
+	LimitAlgorithmGradient = "gradient"
+)
+
+// LimitAlgorithm adjusts the limit of a ConcurrencyLimiter from the latencies
+// of the requests it admitted. Update is called with the lock of the limiter
+// held, so an algorithm must not be shared between limiters.
+type LimitAlgorithm interface {
+	// Limit returns the current limit.
+	Limit() int
+	// Update records the latency of a completed request, which was one of
+	// inFlight requests in flight, and returns the new limit.
+	Update(latency time.Duration, inFlight int) int
+}
+
+// AIMDLimit increases the limit by one while the latencies stay below the
+// threshold and the limit is in use, and multiplies it by the backoff ratio
+// when a latency exceeds the threshold.
+type AIMDLimit struct {
+	limit            int
+	min, max         int
+	latencyThreshold time.Duration
+	backoffRatio     float64
+}
+
+func NewAIMDLimit(initial, min, max int) *AIMDLimit {
+	l := &AIMDLimit{
+		latencyThreshold: time.Second,
+		backoffRatio:     0.9,
+	}
+	l.min, l.max = limitBounds(min, max)
+	l.limit = clampLimit(initial, l.min, l.max)
+	return l
+}
+
+func (l *AIMDLimit) WithLatencyThreshold(threshold time.Duration) *AIMDLimit {
+	if threshold > 0 {
+		l.latencyThreshold = threshold
+	}
+	return l
+}
+
+// WithBackoffRatio sets the factor the limit is multiplied with, between 0.5
+// and 1.
+func (l *AIMDLimit) WithBackoffRatio(ratio float64) *AIMDLimit {
+	if ratio >= 0.5 && ratio < 1 {
+		l.backoffRatio = ratio
+	}
+	return l
+}
+
+func (l *AIMDLimit) Limit() int {
+	return l.limit
+}
+
+func (l *AIMDLimit) Update(latency time.Duration, inFlight int) int {
+	if latency > l.latencyThreshold {
+		l.limit = clampLimit(int(float64(l.limit)*l.backoffRatio), l.min, l.max)
+	} else if inFlight*2 >= l.limit {
+		l.limit = clampLimit(l.limit+1, l.min, l.max)
+	}
+	return l.limit
+}
+
+// GradientLimit compares the latency of each request with the long term
+// average latency, and shrinks the limit as latencies rise above it, as the
+// backend then queues requests. While latencies stay at the average, the limit
+// grows by its square root, so that it follows rising capacity.
+type GradientLimit struct {
+	limit      float64
+	min, max   int
+	tolerance  float64
+	smoothing  float64
+	longRTT    float64
+	longWindow float64
+}
+
+func NewGradientLimit(initial, min, max int) *GradientLimit {
+	l := &GradientLimit{
+		tolerance:  1.5,
+		smoothing:  0.2,
+		longWindow: 600,
+	}
+	l.min, l.max = limitBounds(min, max)
+	l.limit = float64(clampLimit(initial, l.min, l.max))
+	return l
+}
+
+// WithTolerance sets how much latencies may exceed the average, as a factor of
+// at least 1, before the limit shrinks.
+func (l *GradientLimit) WithTolerance(tolerance float64) *GradientLimit {
+	if tolerance >= 1 {
+		l.tolerance = tolerance
+	}
+	return l
+}
+
+// WithSmoothing sets the weight of each update of the limit, between 0 and 1.
+func (l *GradientLimit) WithSmoothing(smoothing float64) *GradientLimit {
+	if smoothing > 0 && smoothing <= 1 {
+		l.smoothing = smoothing
+	}
+	return l
+}
+
+func (l *GradientLimit) Limit() int {
+	return int(l.limit)
+}
+
+func (l *GradientLimit) Update(latency time.Duration, inFlight int) int {
+	rtt := float64(latency)
+	if rtt <= 0 {
+		return int(l.limit)
+	}
+
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) * 2 / (l.longWindow + 1)
+	}
+	// Let the average recover quickly once the backend is fast again.
+	if l.longRTT/rtt > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// The latencies say nothing about the capacity while the limit is not
+	// used.
+	if float64(inFlight) < l.limit/2 {
+		return int(l.limit)
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.tolerance*l.longRTT/rtt))
+	limit := l.limit*gradient + math.Sqrt(l.limit)
+	limit = l.limit*(1-l.smoothing) + limit*l.smoothing
+	l.limit = math.Max(float64(l.min), math.Min(float64(l.max), limit))
+	return int(l.limit)
+}
+
+func limitBounds(min, max int) (int, int) {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = 1000
+		if max < min {
+			max = min
+		}
+	}
+	return min, max
+}
+
+func clampLimit(limit, min, max int) int {
+	if limit < min {
+		return min
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAIMDLimit(t *testing.T) {
+	l := NewAIMDLimit(10, 5, 12).WithLatencyThreshold(100 * time.Millisecond).WithBackoffRatio(0.5)
+
+	steps := []struct {
+		latency  time.Duration
+		inFlight int
+		limit    int
+	}{
+		{10 * time.Millisecond, 2, 10},
+		{10 * time.Millisecond, 5, 11},
+		{10 * time.Millisecond, 11, 12},
+		{10 * time.Millisecond, 12, 12},
+		{200 * time.Millisecond, 12, 6},
+		{200 * time.Millisecond, 6, 5},
+		{10 * time.Millisecond, 5, 6},
+	}
+
+	for i, step := range steps {
+		if actual := l.Update(step.latency, step.inFlight); actual != step.limit {
+			t.Errorf("step %d: invalid limit, expected: %d, actual: %d", i, step.limit, actual)
+		}
+	}
+}
+
+func TestGradientLimit(t *testing.T) {
+	l := NewGradientLimit(20, 5, 100)
+
+	// The limit grows while latencies stay at the average.
+	for i := 0; i < 50; i++ {
+		l.Update(10*time.Millisecond, l.Limit())
+	}
+	grown := l.Limit()
+	if grown <= 20 {
+		t.Errorf("expected limit to grow from 20, actual: %d", grown)
+	}
+
+	// It shrinks once the backend slows down, down to the minimum.
+	for i := 0; i < 50; i++ {
+		l.Update(100*time.Millisecond, l.Limit())
+	}
+	if shrunk := l.Limit(); shrunk >= grown {
+		t.Errorf("expected limit to shrink from %d, actual: %d", grown, shrunk)
+	}
+
+	// It does not shrink below the minimum.
+	l = NewGradientLimit(5, 5, 100).WithSmoothing(1)
+	l.Update(10*time.Millisecond, 0)
+	l.Update(time.Second, 5)
+	if l.Limit() != 5 {
+		t.Errorf("expected limit to stay at the minimum 5, actual: %d", l.Limit())
+	}
+
+	// Unused limits are kept.
+	limit := l.Limit()
+	l.Update(time.Millisecond, 0)
+	if l.Limit() != limit {
+		t.Errorf("expected unused limit %d to be kept, actual: %d", limit, l.Limit())
+	}
+}
+
+func TestConcurrencyLimiter_LimitAlgorithm(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimit{MaxInFlight: 1}).
+		WithLimitAlgorithm(NewAIMDLimit(2, 1, 3).WithLatencyThreshold(time.Hour))
+	ctx := context.Background()
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := l.Acquire(ctx); err != ErrConcurrencyQueueFull {
+		t.Errorf("expected error %v, actual: %v", ErrConcurrencyQueueFull, err)
+	}
+
+	// Fast requests at the limit raise it.
+	releases[0]()
+	if l.Limit() != 3 {
+		t.Errorf("invalid limit, expected: 3, actual: %d", l.Limit())
+	}
+	releases[1]()
+}
+
+type recordingLimit struct {
+	latencies []time.Duration
+}
+
+func (r *recordingLimit) Limit() int {
+	return 1
+}
+
+func (r *recordingLimit) Update(latency time.Duration, inFlight int) int {
+	r.latencies = append(r.latencies, latency)
+	return 1
+}
+
+func TestConcurrencyLimiter_UpstreamLatency(t *testing.T) {
+	algorithm := &recordingLimit{}
+	l := NewConcurrencyLimiter(ConcurrencyLimit{}).WithLimitAlgorithm(algorithm)
+	r, _ := withRequestInfo(httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	release, err := l.Acquire(r.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	SetUpstream(r, "users:8080", time.Millisecond)
+	// Streaming the response to a slow client.
+	time.Sleep(20 * time.Millisecond)
+	release()
+
+	if len(algorithm.latencies) != 1 || algorithm.latencies[0] != time.Millisecond {
+		t.Errorf("invalid latencies, expected: [1ms], actual: %v", algorithm.latencies)
+	}
+}
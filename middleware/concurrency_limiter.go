@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ErrConcurrencyQueueFull    = errors.New("concurrency limit reached and queue full")
+	ErrConcurrencyQueueTimeout = errors.New("timed out waiting in concurrency limit queue")
+	ErrConcurrencyShed         = errors.New("shed for requests of higher priority")
+)
+
+var (
+	gatewayConcurrencyLimit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "gateway_concurrency_limit"},
+		[]string{"route", "target"},
+	)
+	gatewayConcurrencyQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "gateway_concurrency_queue_depth"},
+		[]string{"route", "target"},
+	)
+	gatewayConcurrencyQueueWait = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "gateway_concurrency_queue_wait_seconds"},
+		[]string{"route", "target"},
+	)
+	gatewayConcurrencyRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{Name: "gateway_concurrency_rejected_total"},
+		[]string{"route", "target", "reason", "class"},
+	)
+)
+
+// ConcurrencyLimit allows MaxInFlight requests at once. Up to MaxQueue further
+// requests wait for at most QueueTimeout, others are rejected and told to
+// retry after RetryAfter.
+type ConcurrencyLimit struct {
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
+	RetryAfter   time.Duration
+}
+
+// ConcurrencyLimiter limits the number of requests in flight. Waiting
+// requests are admitted by priority class, and in the order they arrived
+// within a class. When the queue is full, a waiting request of a lower class
+// than the arriving one is shed to make room for it.
+type ConcurrencyLimiter struct {
+	limit         ConcurrencyLimit
+	algorithm     LimitAlgorithm
+	route, target string
+
+	mu          sync.Mutex
+	maxInFlight int
+	inFlight    int
+	queued      int
+	waiters     [numPriorityClasses]*list.List
+}
+
+type concurrencyWaiter struct {
+	class   PriorityClass
+	element *list.Element
+	// done is closed when the request is admitted, or shed with err set.
+	done chan struct{}
+	err  error
+}
+
+func NewConcurrencyLimiter(limit ConcurrencyLimit) *ConcurrencyLimiter {
+	if limit.MaxInFlight < 1 {
+		limit.MaxInFlight = 1
+	}
+	if limit.RetryAfter <= 0 {
+		limit.RetryAfter = time.Second
+	}
+	l := &ConcurrencyLimiter{
+		limit:       limit,
+		maxInFlight: limit.MaxInFlight,
+	}
+	for i := range l.waiters {
+		l.waiters[i] = list.New()
+	}
+	return l
+}
+
+// WithLimitAlgorithm adjusts the limit of requests in flight with the
+// algorithm, starting from its current limit instead of MaxInFlight.
+func (l *ConcurrencyLimiter) WithLimitAlgorithm(algorithm LimitAlgorithm) *ConcurrencyLimiter {
+	l.algorithm = algorithm
+	l.maxInFlight = algorithm.Limit()
+	return l
+}
+
+// WithMetricLabels sets the route and target the metrics of the limiter are
+// labeled with.
+func (l *ConcurrencyLimiter) WithMetricLabels(route, target string) *ConcurrencyLimiter {
+	l.route, l.target = route, target
+	gatewayConcurrencyLimit.WithLabelValues(route, target).Set(float64(l.maxInFlight))
+	return l
+}
+
+// Acquire admits the request, waiting in the queue if the limit is reached.
+// The returned function must be called when the request completed. The
+// priority class of the request is taken from the context, as is its upstream
+// latency once completed, see releaseFunc.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	class := PriorityClassFromContext(ctx)
+
+	l.mu.Lock()
+	if l.inFlight < l.maxInFlight {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.releaseFunc(ctx), nil
+	}
+	if l.queued >= l.limit.MaxQueue && !l.shed(class) {
+		l.mu.Unlock()
+		l.rejected("queue_full", class)
+		return nil, ErrConcurrencyQueueFull
+	}
+	w := &concurrencyWaiter{class: class, done: make(chan struct{})}
+	w.element = l.waiters[class].PushBack(w)
+	l.queued++
+	l.updateQueueDepth()
+	l.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		gatewayConcurrencyQueueWait.WithLabelValues(l.route, l.target).Observe(time.Since(start).Seconds())
+	}()
+
+	var timeout <-chan time.Time
+	if l.limit.QueueTimeout > 0 {
+		timer := time.NewTimer(l.limit.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.done:
+		if w.err != nil {
+			l.rejected("shed", class)
+			return nil, w.err
+		}
+		return l.releaseFunc(ctx), nil
+	case <-timeout:
+		err = ErrConcurrencyQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	select {
+	case <-w.done:
+		if w.err == nil {
+			// Admitted while giving up, the slot is passed on.
+			l.releaseSlot()
+		}
+	default:
+		l.remove(w)
+	}
+	l.mu.Unlock()
+	if err == ErrConcurrencyQueueTimeout {
+		l.rejected("queue_timeout", class)
+	}
+	return nil, err
+}
+
+// releaseFunc returns the function completing an admitted request, which
+// reports its latency to the limit algorithm. That is the upstream latency
+// recorded for the request, see SetUpstream, so that streaming the response to
+// slow clients does not lower the limit. Requests that were not proxied report
+// the time they were admitted for.
+func (l *ConcurrencyLimiter) releaseFunc(ctx context.Context) func() {
+	start := time.Now()
+	return func() {
+		latency := upstreamLatency(ctx)
+		if latency <= 0 {
+			latency = time.Since(start)
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.algorithm != nil {
+			l.maxInFlight = l.algorithm.Update(latency, l.inFlight)
+			gatewayConcurrencyLimit.WithLabelValues(l.route, l.target).Set(float64(l.maxInFlight))
+		}
+		l.releaseSlot()
+	}
+}
+
+// releaseSlot frees the slot of a request, admitting waiting requests while
+// the limit allows.
+func (l *ConcurrencyLimiter) releaseSlot() {
+	l.inFlight--
+	for l.inFlight < l.maxInFlight {
+		w := l.next()
+		if w == nil {
+			return
+		}
+		l.remove(w)
+		l.inFlight++
+		close(w.done)
+	}
+}
+
+// next returns the first waiting request of the highest class.
+func (l *ConcurrencyLimiter) next() *concurrencyWaiter {
+	for class := numPriorityClasses - 1; class >= 0; class-- {
+		if front := l.waiters[class].Front(); front != nil {
+			return front.Value.(*concurrencyWaiter)
+		}
+	}
+	return nil
+}
+
+// shed rejects the last waiting request of the lowest class, if its class is
+// lower than the given class.
+func (l *ConcurrencyLimiter) shed(class PriorityClass) bool {
+	for c := PriorityClassLow; c < class; c++ {
+		if back := l.waiters[c].Back(); back != nil {
+			w := back.Value.(*concurrencyWaiter)
+			l.remove(w)
+			w.err = ErrConcurrencyShed
+			close(w.done)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ConcurrencyLimiter) remove(w *concurrencyWaiter) {
+	l.waiters[w.class].Remove(w.element)
+	l.queued--
+	l.updateQueueDepth()
+}
+
+func (l *ConcurrencyLimiter) updateQueueDepth() {
+	gatewayConcurrencyQueueDepth.WithLabelValues(l.route, l.target).Set(float64(l.queued))
+}
+
+func (l *ConcurrencyLimiter) rejected(reason string, class PriorityClass) {
+	gatewayConcurrencyRejected.WithLabelValues(l.route, l.target, reason, class.String()).Inc()
+}
+
+// Limit returns the current limit of requests in flight.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.maxInFlight
+}
+
+// InFlight returns the number of requests admitted and not yet completed.
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Reject responds to a request which was not admitted.
+func (l *ConcurrencyLimiter) Reject(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", ceilSeconds(l.limit.RetryAfter))
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+const ConcurrencyLimitMiddlewareName = "concurrency_limit"
+
+// ConcurrencyLimitMiddleware limits the requests of a route in flight,
+// responding 503 Service Unavailable to the requests not admitted.
+type ConcurrencyLimitMiddleware struct {
+	limiter *ConcurrencyLimiter
+	bound   sync.Once
+}
+
+func NewConcurrencyLimitMiddleware(limit ConcurrencyLimit) *ConcurrencyLimitMiddleware {
+	return &ConcurrencyLimitMiddleware{limiter: NewConcurrencyLimiter(limit)}
+}
+
+type concurrencyLimitParams struct {
+	MaxInFlight  int           `yaml:"max_in_flight"`
+	MaxQueue     int           `yaml:"max_queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	RetryAfter   time.Duration `yaml:"retry_after"`
+}
+
+func newConcurrencyLimitMiddlewareFromParams(params Params) (Middleware, error) {
+	var p concurrencyLimitParams
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.MaxInFlight < 1 {
+		return nil, fmt.Errorf("invalid max_in_flight %d, must be positive", p.MaxInFlight)
+	}
+	return NewConcurrencyLimitMiddleware(ConcurrencyLimit(p)), nil
+}
+
+func (m *ConcurrencyLimitMiddleware) Name() string {
+	return ConcurrencyLimitMiddlewareName
+}
+
+func (m *ConcurrencyLimitMiddleware) Priority() int {
+	return PriorityConcurrencyLimitMiddleware
+}
+
+// BindRoute labels the metrics with the route. The middleware keeps the route
+// it was first bound to, as requests may be in flight when routes are set again.
+func (m *ConcurrencyLimitMiddleware) BindRoute(path string) {
+	m.bound.Do(func() {
+		m.limiter.WithMetricLabels(path, "")
+	})
+}
+
+func (m *ConcurrencyLimitMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, err := m.limiter.Acquire(r.Context())
+		if err != nil {
+			m.limiter.Reject(w, err)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_Queue(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 2, QueueTimeout: time.Minute})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	admitted := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func(i int) {
+			release, err := l.Acquire(ctx)
+			if err != nil {
+				t.Errorf("unexpected error of waiting request %d: %v", i, err)
+				return
+			}
+			admitted <- i
+			release()
+		}(i)
+		waitForQueue(t, l, i)
+	}
+
+	if _, err := l.Acquire(ctx); err != ErrConcurrencyQueueFull {
+		t.Errorf("expected error %v, actual: %v", ErrConcurrencyQueueFull, err)
+	}
+
+	release()
+	for expected := 1; expected <= 2; expected++ {
+		if actual := <-admitted; actual != expected {
+			t.Errorf("invalid order of admission, expected: %d, actual: %d", expected, actual)
+		}
+	}
+	if l.InFlight() != 0 {
+		t.Errorf("expected no requests in flight, actual: %d", l.InFlight())
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond})
+
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	if _, err := l.Acquire(context.Background()); err != ErrConcurrencyQueueTimeout {
+		t.Errorf("expected error %v, actual: %v", ErrConcurrencyQueueTimeout, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx); err != context.Canceled {
+		t.Errorf("expected error %v, actual: %v", context.Canceled, err)
+	}
+	if l.queued != 0 || l.InFlight() != 1 {
+		t.Errorf("expected waiters to leave the queue, waiting: %d, in flight: %d", l.queued, l.InFlight())
+	}
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	m, err := New(ConcurrencyLimitMiddlewareName, Params{
+		"max_in_flight": 1,
+		"retry_after":   "3s",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.(RouteBinder).BindRoute("/users")
+
+	inFlight, done := make(chan struct{}), make(chan struct{})
+	handler := m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-done
+	})
+	go handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	<-inFlight
+	defer close(done)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if actual := w.Header().Get("Retry-After"); actual != "3" {
+		t.Errorf("invalid Retry-After, expected: 3, actual: %s", actual)
+	}
+
+	if _, err := New(ConcurrencyLimitMiddlewareName, Params{}); err == nil {
+		t.Error("expected error of missing max_in_flight, none occurred")
+	}
+}
+
+func waitForQueue(t *testing.T, l *ConcurrencyLimiter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		waiting := l.queued
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d waiting requests", n)
+}
+
+func TestConcurrencyLimiter_PriorityClasses(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 2, QueueTimeout: time.Minute})
+	withClass := func(class PriorityClass) context.Context {
+		return WithPriorityClass(context.Background(), class)
+	}
+
+	release, err := l.Acquire(withClass(PriorityClassLow))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results := make(chan PriorityClass, 3)
+	errs := make(chan error, 3)
+	wait := func(class PriorityClass) {
+		release, err := l.Acquire(withClass(class))
+		if err != nil {
+			errs <- err
+			return
+		}
+		results <- class
+		release()
+	}
+
+	go wait(PriorityClassLow)
+	waitForQueue(t, l, 1)
+	go wait(PriorityClassNormal)
+	waitForQueue(t, l, 2)
+
+	// The queue is full, the low priority request is shed for the high one.
+	go wait(PriorityClassHigh)
+	if err := <-errs; err != ErrConcurrencyShed {
+		t.Errorf("expected error %v, actual: %v", ErrConcurrencyShed, err)
+	}
+	waitForQueue(t, l, 2)
+
+	// Requests of the lowest class are rejected while the queue is full.
+	if _, err := l.Acquire(withClass(PriorityClassLow)); err != ErrConcurrencyQueueFull {
+		t.Errorf("expected error %v, actual: %v", ErrConcurrencyQueueFull, err)
+	}
+
+	release()
+	for _, expected := range []PriorityClass{PriorityClassHigh, PriorityClassNormal} {
+		if actual := <-results; actual != expected {
+			t.Errorf("invalid order of admission, expected: %v, actual: %v", expected, actual)
+		}
+	}
+}
+
+func TestPriorityClassMiddleware(t *testing.T) {
+	m, err := New(PriorityClassMiddlewareName, Params{"class": "low", "header": "x-priority"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		header   string
+		expected PriorityClass
+	}{
+		{"", PriorityClassLow},
+		{"critical", PriorityClassCritical},
+		{"HIGH", PriorityClassHigh},
+		{"urgent", PriorityClassLow},
+	}
+
+	for _, c := range cases {
+		var actual PriorityClass
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.header != "" {
+			r.Header.Set("X-Priority", c.header)
+		}
+		m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+			actual = PriorityClassFromContext(r.Context())
+		})(httptest.NewRecorder(), r)
+
+		if actual != c.expected {
+			t.Errorf("header '%s': invalid class, expected: %v, actual: %v", c.header, c.expected, actual)
+		}
+	}
+
+	if _, err := New(PriorityClassMiddlewareName, Params{"class": "urgent"}); err == nil {
+		t.Error("expected error of unknown class, none occurred")
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Consumer is a client of the gateway identified by its API keys.
+type Consumer struct {
+	Id     string
+	Groups []string
+}
+
+// InGroup reports whether the consumer is a member of any of the groups.
+func (c *Consumer) InGroup(groups ...string) bool {
+	for _, group := range groups {
+		for _, g := range c.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConsumerRegistry looks up consumers by their API keys. Only the SHA-256
+// hashes of the keys are kept.
+type ConsumerRegistry struct {
+	consumers map[string]*Consumer
+	byHash    map[string]*Consumer
+}
+
+func NewConsumerRegistry() *ConsumerRegistry {
+	return &ConsumerRegistry{
+		consumers: map[string]*Consumer{},
+		byHash:    map[string]*Consumer{},
+	}
+}
+
+// WithConsumer adds the consumer with the hex encoded SHA-256 hashes of its
+// API keys, e.g. the output of `printf %s "$key" | sha256sum`.
+func (r *ConsumerRegistry) WithConsumer(consumer Consumer, keyHashes ...string) (*ConsumerRegistry, error) {
+	if consumer.Id == "" {
+		return nil, fmt.Errorf("consumer without id")
+	}
+	if _, ok := r.consumers[consumer.Id]; ok {
+		return nil, fmt.Errorf("duplicate consumer '%s'", consumer.Id)
+	}
+
+	c := &consumer
+	for _, keyHash := range keyHashes {
+		keyHash = strings.ToLower(keyHash)
+		if b, err := hex.DecodeString(keyHash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid key hash of consumer '%s', expected hex encoded SHA-256", consumer.Id)
+		}
+		if other, ok := r.byHash[keyHash]; ok {
+			return nil, fmt.Errorf("consumers '%s' and '%s' share a key", other.Id, consumer.Id)
+		}
+		r.byHash[keyHash] = c
+	}
+	r.consumers[c.Id] = c
+	return r, nil
+}
+
+// Lookup returns the consumer of the API key, or nil if the key is unknown.
+func (r *ConsumerRegistry) Lookup(key string) *Consumer {
+	sum := sha256.Sum256([]byte(key))
+	return r.byHash[hex.EncodeToString(sum[:])]
+}
+
+var consumers atomic.Value
+
+// SetConsumers replaces the consumers the KeyAuthMiddleware authenticates
+// against, unless it was given its own registry.
+func SetConsumers(registry *ConsumerRegistry) {
+	consumers.Store(registry)
+}
+
+func currentConsumers() *ConsumerRegistry {
+	registry, _ := consumers.Load().(*ConsumerRegistry)
+	return registry
+}
+
+type consumerKey struct{}
+
+// ConsumerFromContext returns the consumer authenticated by the
+// KeyAuthMiddleware, or nil if the request is not authenticated.
+func ConsumerFromContext(ctx context.Context) *Consumer {
+	c, _ := ctx.Value(consumerKey{}).(*Consumer)
+	return c
+}
+
+// withConsumer returns the request carrying the consumer, and records it for
+// the access log and metrics.
+func withConsumer(r *http.Request, c *Consumer) *http.Request {
+	if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		ri.consumer = c.Id
+	}
+	return r.WithContext(context.WithValue(r.Context(), consumerKey{}, c))
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CorsMiddlewareName = "cors"
+
+var defaultCorsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// CorsMiddleware answers CORS preflight requests and adds the CORS headers to
+// the responses of allowed origins. Preflight requests only reach it if the
+// route accepts the OPTIONS method.
+type CorsMiddleware struct {
+	allowedOrigins   []string
+	allowedMethods   []string
+	allowedHeaders   []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+func NewCorsMiddleware() *CorsMiddleware {
+	return &CorsMiddleware{
+		allowedMethods: defaultCorsMethods,
+	}
+}
+
+// WithAllowedOrigins sets the allowed origins, "*" allows any origin.
+func (m *CorsMiddleware) WithAllowedOrigins(origins ...string) *CorsMiddleware {
+	m.allowedOrigins = origins
+	return m
+}
+
+func (m *CorsMiddleware) WithAllowedMethods(methods ...string) *CorsMiddleware {
+	if len(methods) > 0 {
+		m.allowedMethods = methods
+	}
+	return m
+}
+
+// WithAllowedHeaders sets the request headers allowed, "*" allows the headers
+// requested by the preflight request.
+func (m *CorsMiddleware) WithAllowedHeaders(headers ...string) *CorsMiddleware {
+	m.allowedHeaders = headers
+	return m
+}
+
+func (m *CorsMiddleware) WithExposedHeaders(headers ...string) *CorsMiddleware {
+	m.exposedHeaders = headers
+	return m
+}
+
+func (m *CorsMiddleware) WithAllowCredentials(allowCredentials bool) *CorsMiddleware {
+	m.allowCredentials = allowCredentials
+	return m
+}
+
+func (m *CorsMiddleware) WithMaxAge(maxAge time.Duration) *CorsMiddleware {
+	m.maxAge = maxAge
+	return m
+}
+
+type corsParams struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+func newCorsMiddlewareFromParams(params Params) (Middleware, error) {
+	var p corsParams
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	return NewCorsMiddleware().
+		WithAllowedOrigins(p.AllowedOrigins...).
+		WithAllowedMethods(p.AllowedMethods...).
+		WithAllowedHeaders(p.AllowedHeaders...).
+		WithExposedHeaders(p.ExposedHeaders...).
+		WithAllowCredentials(p.AllowCredentials).
+		WithMaxAge(p.MaxAge), nil
+}
+
+func (m *CorsMiddleware) Name() string {
+	return CorsMiddlewareName
+}
+
+func (m *CorsMiddleware) Priority() int {
+	return PriorityCorsMiddleware
+}
+
+func (m *CorsMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !m.originAllowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if preflight {
+			m.preflight(w, r, origin)
+			return
+		}
+
+		rules := HeaderRules{Set: map[string]string{"Access-Control-Allow-Origin": m.allowOrigin(origin)}}
+		if m.allowCredentials {
+			rules.Set["Access-Control-Allow-Credentials"] = "true"
+		}
+		if len(m.exposedHeaders) > 0 {
+			rules.Set["Access-Control-Expose-Headers"] = strings.Join(m.exposedHeaders, ", ")
+		}
+		next.ServeHTTP(&headersResponseWriter{ResponseWriter: w, rules: rules}, r)
+	}
+}
+
+func (m *CorsMiddleware) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !containsFold(m.allowedMethods, method) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", m.allowOrigin(origin))
+	header.Set("Access-Control-Allow-Methods", strings.Join(m.allowedMethods, ", "))
+	if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		if containsFold(m.allowedHeaders, "*") {
+			header.Set("Access-Control-Allow-Headers", requested)
+		} else if len(m.allowedHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(m.allowedHeaders, ", "))
+		}
+	}
+	if m.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if m.maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(m.maxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *CorsMiddleware) originAllowed(origin string) bool {
+	return containsFold(m.allowedOrigins, "*") || containsFold(m.allowedOrigins, origin)
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin, which must
+// name the origin when credentials are allowed.
+func (m *CorsMiddleware) allowOrigin(origin string) string {
+	if containsFold(m.allowedOrigins, "*") && !m.allowCredentials {
+		return "*"
+	}
+	return origin
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCorsMiddleware(t *testing.T) {
+	m := NewCorsMiddleware().
+		WithAllowedOrigins("https://app.example.com").
+		WithAllowedMethods(http.MethodGet, http.MethodPut).
+		WithAllowedHeaders("*").
+		WithExposedHeaders("X-Request-Id").
+		WithAllowCredentials(true).
+		WithMaxAge(10 * time.Minute)
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		requestMethod string
+		status        int
+		headers       map[string]string
+		backend       bool
+	}{
+		{
+			name:    "no origin",
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+			backend: true,
+		},
+		{
+			name:   "allowed origin",
+			method: http.MethodGet,
+			origin: "https://app.example.com",
+			status: http.StatusOK,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Request-Id",
+			},
+			backend: true,
+		},
+		{
+			name:    "other origin",
+			method:  http.MethodGet,
+			origin:  "https://evil.example.com",
+			status:  http.StatusOK,
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+			backend: true,
+		},
+		{
+			name:          "preflight",
+			method:        http.MethodOptions,
+			origin:        "https://app.example.com",
+			requestMethod: http.MethodPut,
+			status:        http.StatusNoContent,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, PUT",
+				"Access-Control-Allow-Headers": "Content-Type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:          "preflight of method not allowed",
+			method:        http.MethodOptions,
+			origin:        "https://app.example.com",
+			requestMethod: http.MethodDelete,
+			status:        http.StatusForbidden,
+		},
+		{
+			name:          "preflight of other origin",
+			method:        http.MethodOptions,
+			origin:        "https://evil.example.com",
+			requestMethod: http.MethodGet,
+			status:        http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		backend := false
+		handler := m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+			backend = true
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(tt.method, "/", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: invalid status, expected: %d, actual: %d", tt.name, tt.status, w.Code)
+		}
+		if backend != tt.backend {
+			t.Errorf("%s: invalid call of backend, expected: %v, actual: %v", tt.name, tt.backend, backend)
+		}
+		for name, expected := range tt.headers {
+			if actual := w.Header().Get(name); actual != expected {
+				t.Errorf("%s: invalid %s, expected: '%s', actual: '%s'", tt.name, name, expected, actual)
+			}
+		}
+	}
+}
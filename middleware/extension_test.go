@@ -0,0 +1,105 @@
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cdmatta/api-gw/middleware"
+)
+
+// tenantMiddleware is a middleware of another package, tagging requests with
+// a tenant.
+type tenantMiddleware struct {
+	header      string
+	tenant      string
+	initialized bool
+	closed      bool
+}
+
+func (m *tenantMiddleware) Name() string {
+	return "tenant"
+}
+
+func (m *tenantMiddleware) Priority() int {
+	return middleware.PriorityCustomMiddleware
+}
+
+func (m *tenantMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(m.header, m.tenant)
+		next(w, r)
+	}
+}
+
+func (m *tenantMiddleware) Init() error {
+	if m.tenant == "" {
+		return errors.New("no tenant")
+	}
+	m.initialized = true
+	return nil
+}
+
+func (m *tenantMiddleware) Close() error {
+	m.closed = true
+	return nil
+}
+
+func init() {
+	middleware.RegisterMiddleware("tenant", func(params middleware.Params) (middleware.Middleware, error) {
+		m := &tenantMiddleware{header: "X-Tenant"}
+		var p struct {
+			Header string `yaml:"header"`
+			Tenant string `yaml:"tenant"`
+		}
+		if err := params.Decode(&p); err != nil {
+			return nil, err
+		}
+		if p.Header != "" {
+			m.header = p.Header
+		}
+		m.tenant = p.Tenant
+		return m, nil
+	})
+}
+
+func TestRegisterMiddleware(t *testing.T) {
+	m, err := middleware.New("tenant", middleware.Params{"tenant": "acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tenant := m.(*tenantMiddleware)
+	if !tenant.initialized {
+		t.Error("middleware not initialized")
+	}
+
+	var header string
+	handler := middleware.Compose(m)(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Tenant")
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if header != "acme" {
+		t.Errorf("invalid X-Tenant header, expected: '%s', actual: '%s'", "acme", header)
+	}
+
+	middleware.Close(m)
+	if !tenant.closed {
+		t.Error("middleware not closed")
+	}
+
+	if _, err := middleware.New("tenant", nil); err == nil {
+		t.Error("expected error of Init, none occurred")
+	}
+}
+
+func TestRegisterMiddleware_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate name, none occurred")
+		}
+	}()
+	middleware.RegisterMiddleware(middleware.HeadersMiddlewareName, func(middleware.Params) (middleware.Middleware, error) {
+		return middleware.NewHeadersMiddleware(), nil
+	})
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import "net/http"
+
+const HeadersMiddlewareName = "headers"
+
+// HeaderRules set and remove headers, removing before setting.
+type HeaderRules struct {
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
+}
+
+func (r HeaderRules) apply(header http.Header) {
+	for _, name := range r.Remove {
+		header.Del(name)
+	}
+	for name, value := range r.Set {
+		header.Set(name, value)
+	}
+}
+
+func (r HeaderRules) empty() bool {
+	return len(r.Set) == 0 && len(r.Remove) == 0
+}
+
+// HeadersMiddleware modifies the headers of requests before they are passed
+// on, and of responses before they are sent to the client.
+type HeadersMiddleware struct {
+	request  HeaderRules
+	response HeaderRules
+}
+
+func NewHeadersMiddleware() *HeadersMiddleware {
+	return &HeadersMiddleware{}
+}
+
+func (m *HeadersMiddleware) WithRequestHeaders(rules HeaderRules) *HeadersMiddleware {
+	m.request = rules
+	return m
+}
+
+func (m *HeadersMiddleware) WithResponseHeaders(rules HeaderRules) *HeadersMiddleware {
+	m.response = rules
+	return m
+}
+
+type headersParams struct {
+	Request  HeaderRules `yaml:"request"`
+	Response HeaderRules `yaml:"response"`
+}
+
+func newHeadersMiddlewareFromParams(params Params) (Middleware, error) {
+	var p headersParams
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	return NewHeadersMiddleware().WithRequestHeaders(p.Request).WithResponseHeaders(p.Response), nil
+}
+
+func (m *HeadersMiddleware) Name() string {
+	return HeadersMiddlewareName
+}
+
+func (m *HeadersMiddleware) Priority() int {
+	return PriorityHeadersMiddleware
+}
+
+func (m *HeadersMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.request.apply(r.Header)
+		if !m.response.empty() {
+			w = &headersResponseWriter{ResponseWriter: w, rules: m.response}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// headersResponseWriter applies the rules when the header is written, after
+// the headers of the backend response have been copied.
+type headersResponseWriter struct {
+	http.ResponseWriter
+	rules       HeaderRules
+	wroteHeader bool
+}
+
+func (w *headersResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.rules.apply(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headersResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headersResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
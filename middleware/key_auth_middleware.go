@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	KeyAuthMiddlewareName = "key_auth"
+
+	DefaultKeyAuthHeader = "X-Api-Key"
+)
+
+var gatewayKeyAuthRejected = promauto.NewCounterVec(
+	prometheus.CounterOpts{Name: "gateway_key_auth_rejected_total"},
+	[]string{"uri", "reason"},
+)
+
+// KeyAuthMiddleware authenticates requests by the API keys of the consumers,
+// taken from a header, and optionally a query parameter or a cookie. The key is
+// removed from the request, so that it does not reach the backends.
+//
+// Requests already authenticated, e.g. by a global KeyAuthMiddleware, are only
+// checked for the groups of the middleware. Filters using the consumer, e.g. a
+// RateLimitMiddleware keyed by consumer, must be applied after it.
+type KeyAuthMiddleware struct {
+	header   string
+	query    string
+	cookie   string
+	groups   []string
+	registry *ConsumerRegistry
+}
+
+func NewKeyAuthMiddleware() *KeyAuthMiddleware {
+	return &KeyAuthMiddleware{header: DefaultKeyAuthHeader}
+}
+
+func (m *KeyAuthMiddleware) WithHeader(header string) *KeyAuthMiddleware {
+	if header != "" {
+		m.header = http.CanonicalHeaderKey(header)
+	}
+	return m
+}
+
+// WithQuery reads the key from the query parameter, if the header is missing.
+func (m *KeyAuthMiddleware) WithQuery(name string) *KeyAuthMiddleware {
+	m.query = name
+	return m
+}
+
+// WithCookie reads the key from the cookie, if the header and query parameter
+// are missing.
+func (m *KeyAuthMiddleware) WithCookie(name string) *KeyAuthMiddleware {
+	m.cookie = name
+	return m
+}
+
+// WithGroups only admits consumers which are members of any of the groups.
+func (m *KeyAuthMiddleware) WithGroups(groups ...string) *KeyAuthMiddleware {
+	m.groups = groups
+	return m
+}
+
+// WithConsumers authenticates against the registry instead of the consumers of
+// the configuration, see SetConsumers.
+func (m *KeyAuthMiddleware) WithConsumers(registry *ConsumerRegistry) *KeyAuthMiddleware {
+	m.registry = registry
+	return m
+}
+
+type keyAuthParams struct {
+	Header string   `yaml:"header"`
+	Query  string   `yaml:"query"`
+	Cookie string   `yaml:"cookie"`
+	Groups []string `yaml:"groups"`
+}
+
+func newKeyAuthMiddlewareFromParams(params Params) (Middleware, error) {
+	var p keyAuthParams
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	return NewKeyAuthMiddleware().
+		WithHeader(p.Header).
+		WithQuery(p.Query).
+		WithCookie(p.Cookie).
+		WithGroups(p.Groups...), nil
+}
+
+func (m *KeyAuthMiddleware) Name() string {
+	return KeyAuthMiddlewareName
+}
+
+func (m *KeyAuthMiddleware) Priority() int {
+	return PriorityKeyAuthMiddleware
+}
+
+func (m *KeyAuthMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := ConsumerFromContext(r.Context())
+		if c == nil {
+			key := m.requestKey(r)
+			if key == "" {
+				m.reject(w, r, http.StatusUnauthorized, "missing_key", "missing API key")
+				return
+			}
+			m.stripKey(r)
+			if c = m.consumers().Lookup(key); c == nil {
+				m.reject(w, r, http.StatusUnauthorized, "invalid_key", "invalid API key")
+				return
+			}
+			r = withConsumer(r, c)
+		}
+
+		if len(m.groups) > 0 && !c.InGroup(m.groups...) {
+			m.reject(w, r, http.StatusForbidden, "forbidden", "consumer not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (m *KeyAuthMiddleware) consumers() *ConsumerRegistry {
+	if m.registry != nil {
+		return m.registry
+	}
+	if registry := currentConsumers(); registry != nil {
+		return registry
+	}
+	return NewConsumerRegistry()
+}
+
+func (m *KeyAuthMiddleware) requestKey(r *http.Request) string {
+	if key := r.Header.Get(m.header); key != "" {
+		return key
+	}
+	if m.query != "" {
+		if key := r.URL.Query().Get(m.query); key != "" {
+			return key
+		}
+	}
+	if m.cookie != "" {
+		if cookie, err := r.Cookie(m.cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// stripKey removes the key from all places it may be sent in, and keeps it out
+// of the access log.
+func (m *KeyAuthMiddleware) stripKey(r *http.Request) {
+	r.Header.Del(m.header)
+
+	if m.query != "" {
+		if query := r.URL.Query(); query[m.query] != nil {
+			query.Del(m.query)
+			r.URL.RawQuery = query.Encode()
+			if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+				ri.uri = r.URL.RequestURI()
+			}
+		}
+	}
+
+	if _, err := r.Cookie(m.cookie); m.cookie != "" && err == nil {
+		cookies := r.Cookies()
+		r.Header.Del("Cookie")
+		for _, cookie := range cookies {
+			if cookie.Name != m.cookie {
+				r.AddCookie(cookie)
+			}
+		}
+	}
+}
+
+func (m *KeyAuthMiddleware) reject(w http.ResponseWriter, r *http.Request, status int, reason, message string) {
+	gatewayKeyAuthRejected.WithLabelValues(routeTemplate(r), reason).Inc()
+	http.Error(w, message, status)
+}
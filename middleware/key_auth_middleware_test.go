@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestConsumers(t *testing.T) *ConsumerRegistry {
+	registry, err := NewConsumerRegistry().WithConsumer(Consumer{Id: "billing", Groups: []string{"internal"}}, hashKey("billing-key"))
+	if err == nil {
+		registry, err = registry.WithConsumer(Consumer{Id: "partner", Groups: []string{"partners"}}, hashKey("partner-key"), strings.ToUpper(hashKey("partner-key-2")))
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return registry
+}
+
+func TestKeyAuthMiddleware(t *testing.T) {
+	m := NewKeyAuthMiddleware().
+		WithQuery("api_key").
+		WithCookie("api_key").
+		WithGroups("internal", "partners").
+		WithConsumers(newTestConsumers(t))
+
+	tests := []struct {
+		name     string
+		setup    func(r *http.Request)
+		status   int
+		consumer string
+	}{
+		{"header", func(r *http.Request) { r.Header.Set("X-Api-Key", "billing-key") }, http.StatusOK, "billing"},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "api_key=partner-key-2&page=2" }, http.StatusOK, "partner"},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "api_key", Value: "partner-key"}) }, http.StatusOK, "partner"},
+		{"missing", func(r *http.Request) {}, http.StatusUnauthorized, ""},
+		{"invalid", func(r *http.Request) { r.Header.Set("X-Api-Key", "guessed") }, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			tt.setup(r)
+
+			var consumer string
+			var forwarded *http.Request
+			w := httptest.NewRecorder()
+			m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+				consumer = ConsumerFromContext(r.Context()).Id
+				forwarded = r
+			})(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("invalid status, expected: %d, actual: %d", tt.status, w.Code)
+			}
+			if consumer != tt.consumer {
+				t.Errorf("invalid consumer, expected: '%s', actual: '%s'", tt.consumer, consumer)
+			}
+			if forwarded == nil {
+				return
+			}
+			if forwarded.Header.Get("X-Api-Key") != "" || forwarded.URL.Query().Get("api_key") != "" {
+				t.Errorf("expected key to be stripped, headers: %v, query: %s", forwarded.Header, forwarded.URL.RawQuery)
+			}
+			if _, err := forwarded.Cookie("api_key"); err == nil {
+				t.Error("expected key cookie to be stripped")
+			}
+			if _, err := forwarded.Cookie("session"); err != nil {
+				t.Error("expected other cookies to be kept")
+			}
+		})
+	}
+}
+
+func TestKeyAuthMiddleware_Groups(t *testing.T) {
+	consumers := newTestConsumers(t)
+	authenticate := NewKeyAuthMiddleware().WithConsumers(consumers)
+	internalOnly, err := New(KeyAuthMiddlewareName, Params{"groups": []string{"internal"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The route filter checks the groups of the consumer authenticated by the
+	// global filter.
+	handler := Chain(authenticate, internalOnly)(func(w http.ResponseWriter, r *http.Request) {})
+	for key, expected := range map[string]int{"billing-key": http.StatusOK, "partner-key": http.StatusForbidden} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if w.Code != expected {
+			t.Errorf("key '%s': invalid status, expected: %d, actual: %d", key, expected, w.Code)
+		}
+	}
+}
+
+func TestKeyAuthMiddleware_AccessLog(t *testing.T) {
+	var out bytes.Buffer
+	accessLog, _ := NewAccessLog(&out).WithFormat(AccessLogFormatTemplate, "$uri $consumer")
+	auth := NewKeyAuthMiddleware().WithQuery("api_key").WithConsumers(newTestConsumers(t))
+	handler := Chain(NewAccessLoggingMetricsMiddleware().WithAccessLog(accessLog), auth)(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders?api_key=billing-key&page=2", nil))
+
+	if expected := "/orders?page=2 billing\n"; out.String() != expected {
+		t.Errorf("invalid access log, expected: %q, actual: %q", expected, out.String())
+	}
+}
+
+func TestConsumerRegistry_InvalidConsumers(t *testing.T) {
+	tests := []struct {
+		name      string
+		consumers []Consumer
+		hashes    [][]string
+	}{
+		{"missing id", []Consumer{{}}, [][]string{nil}},
+		{"plain key", []Consumer{{Id: "a"}}, [][]string{{"secret"}}},
+		{"duplicate id", []Consumer{{Id: "a"}, {Id: "a"}}, [][]string{nil, nil}},
+		{"shared key", []Consumer{{Id: "a"}, {Id: "b"}}, [][]string{{hashKey("k")}, {hashKey("k")}}},
+	}
+
+	for _, tt := range tests {
+		registry := NewConsumerRegistry()
+		var err error
+		for i, c := range tt.consumers {
+			if _, err = registry.WithConsumer(c, tt.hashes[i]...); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("%s: expected error, none occurred", tt.name)
+		}
+	}
+}
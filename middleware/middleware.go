@@ -7,24 +7,58 @@ import (
 
 type FilterFunctionAdaptor func(http.HandlerFunc) http.HandlerFunc
 
+// Middleware filters the requests of the gateway. Global middlewares are
+// ordered by priority, lower priorities wrapping higher ones, the middlewares
+// of a route apply in the order they are configured. Other packages implement
+// it to add middlewares, see RegisterMiddleware. The name of a registered
+// middleware is the name it is registered under.
 type Middleware interface {
-	getPriority() int
+	Name() string
+	Priority() int
 	FilterFunction(http.HandlerFunc) http.HandlerFunc
 }
 
 func Compose(middlewares ...Middleware) FilterFunctionAdaptor {
-	sort.Slice(middlewares, func(i, j int) bool {
-		return middlewares[i].getPriority() < middlewares[j].getPriority()
+	sort.SliceStable(middlewares, func(i, j int) bool {
+		return middlewares[i].Priority() < middlewares[j].Priority()
 	})
 
-	return func(next http.HandlerFunc) http.HandlerFunc {
-		for i := len(middlewares) - 1; i >= 0; i-- {
-			next = middlewares[i].FilterFunction(next)
-		}
-		return next
-	}
+	return Chain(middlewares...)
 }
 
 const (
-	PriorityAccessLoggingMetricsMiddleware = iota
+	PriorityRequestIdMiddleware = iota
+	PriorityTracingMiddleware
+	PriorityAccessLoggingMetricsMiddleware
+	PriorityCorsMiddleware
+	PriorityKeyAuthMiddleware
+	PriorityHeadersMiddleware
+	PriorityPriorityClassMiddleware
+	PriorityRateLimitMiddleware
+	PriorityConcurrencyLimitMiddleware
 )
+
+// PriorityCustomMiddleware is a suggested priority for middlewares of other
+// packages, placing them inside the middlewares of the gateway.
+const PriorityCustomMiddleware = 100
+
+// Initializer is implemented by middlewares which set up resources, e.g.
+// connections, before they handle requests. Init is called after the
+// middleware is built from the configuration.
+type Initializer interface {
+	Init() error
+}
+
+// Closer is implemented by middlewares which release resources. Close is
+// called when the route of the middleware is removed, once the requests in
+// flight on the route completed.
+type Closer interface {
+	Close() error
+}
+
+// RouteBinder is implemented by route middlewares which need the path of their
+// route, e.g. to label metrics. BindRoute is called whenever the routes of the
+// gateway are set, before the middleware handles requests of a new route.
+type RouteBinder interface {
+	BindRoute(path string)
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const PriorityClassMiddlewareName = "priority_class"
+
+// PriorityClass orders requests when they are shed. Concurrency limiters admit
+// waiting requests of higher classes first, and reject those of the lowest
+// class first when their queue is full.
+type PriorityClass int
+
+const (
+	PriorityClassLow PriorityClass = iota
+	PriorityClassNormal
+	PriorityClassHigh
+	PriorityClassCritical
+
+	numPriorityClasses = int(PriorityClassCritical) + 1
+)
+
+var priorityClassNames = [numPriorityClasses]string{"low", "normal", "high", "critical"}
+
+func (c PriorityClass) String() string {
+	if c < 0 || int(c) >= numPriorityClasses {
+		return fmt.Sprintf("PriorityClass(%d)", int(c))
+	}
+	return priorityClassNames[c]
+}
+
+// ParsePriorityClass parses the name of a priority class, e.g. "high".
+func ParsePriorityClass(name string) (PriorityClass, error) {
+	for i, n := range priorityClassNames {
+		if strings.EqualFold(name, n) {
+			return PriorityClass(i), nil
+		}
+	}
+	return PriorityClassNormal, fmt.Errorf("unknown priority class '%s'", name)
+}
+
+type priorityClassKey struct{}
+
+// WithPriorityClass returns the context carrying the priority class of the
+// request.
+func WithPriorityClass(ctx context.Context, class PriorityClass) context.Context {
+	return context.WithValue(ctx, priorityClassKey{}, class)
+}
+
+// PriorityClassFromContext returns the priority class of the request, which is
+// PriorityClassNormal unless set by the PriorityClassMiddleware.
+func PriorityClassFromContext(ctx context.Context) PriorityClass {
+	if class, ok := ctx.Value(priorityClassKey{}).(PriorityClass); ok {
+		return class
+	}
+	return PriorityClassNormal
+}
+
+// PriorityClassMiddleware assigns requests a priority class, taken from a
+// header of the request if set, and otherwise the class of the middleware.
+// As clients may claim any class with the header, it should only be read from
+// trusted clients.
+type PriorityClassMiddleware struct {
+	class  PriorityClass
+	header string
+}
+
+func NewPriorityClassMiddleware(class PriorityClass) *PriorityClassMiddleware {
+	return &PriorityClassMiddleware{class: class}
+}
+
+// WithHeader sets the header the priority class is read from. Requests without
+// the header, or with an unknown class, keep the class of the middleware.
+func (m *PriorityClassMiddleware) WithHeader(header string) *PriorityClassMiddleware {
+	m.header = http.CanonicalHeaderKey(header)
+	return m
+}
+
+type priorityClassParams struct {
+	Class  string `yaml:"class"`
+	Header string `yaml:"header"`
+}
+
+func newPriorityClassMiddlewareFromParams(params Params) (Middleware, error) {
+	var p priorityClassParams
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	class := PriorityClassNormal
+	if p.Class != "" {
+		var err error
+		if class, err = ParsePriorityClass(p.Class); err != nil {
+			return nil, err
+		}
+	}
+	return NewPriorityClassMiddleware(class).WithHeader(p.Header), nil
+}
+
+func (m *PriorityClassMiddleware) Name() string {
+	return PriorityClassMiddlewareName
+}
+
+func (m *PriorityClassMiddleware) Priority() int {
+	return PriorityPriorityClassMiddleware
+}
+
+func (m *PriorityClassMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		class := m.class
+		if m.header != "" {
+			if value := r.Header.Get(m.header); value != "" {
+				if c, err := ParsePriorityClass(value); err == nil {
+					class = c
+				}
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(WithPriorityClass(r.Context(), class)))
+	}
+}
@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	RateLimitMiddlewareName = "rate_limit"
+
+	RateLimitKeyIP       = "ip"
+	RateLimitKeyHeader   = "header"
+	RateLimitKeyJWTClaim = "jwt_claim"
+	RateLimitKeyRoute    = "route"
+	RateLimitKeyConsumer = "consumer"
+
+	DefaultRateLimitHeader = "X-Api-Key"
+	DefaultRateLimitClaim  = "sub"
+)
+
+var ErrPatternUnknownRateLimitKey = "unknown rate limit key '%s'"
+
+var (
+	gatewayRateLimitedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{Name: "gateway_rate_limited_requests_total"},
+		[]string{"uri", "key"},
+	)
+	gatewayRateLimitStoreFallbacks = promauto.NewCounter(
+		prometheus.CounterOpts{Name: "gateway_rate_limit_store_fallbacks_total"},
+	)
+)
+
+// RateLimitMiddleware limits the rate of requests per key with token buckets.
+// Requests without the header, claim or consumer they are keyed by are limited
+// by the client IP, so that omitting it does not lift the limit. The client IP
+// is resolved through the trusted proxies of the gateway, see ClientIP.
+//
+// The header and the claim are not verified, a client sending a new value on
+// every request gets a new bucket every time. They are only fit as keys when
+// the rate limit follows a filter authenticating them, e.g. key_auth for the
+// header of the API key.
+//
+// Requests keyed by route are limited per route template, which is only known
+// to rate limits of routes. The gateway rejects global ones keyed by route.
+//
+// The consumer is set by a KeyAuthMiddleware applied before the rate limit,
+// i.e. a global one for a global rate limit, which is applied before the
+// filters of the routes. The gateway rejects configurations which do not.
+//
+// The token buckets are kept in memory, unless a store is set. Requests are
+// limited in memory while the store fails.
+type RateLimitMiddleware struct {
+	limit   RateLimit
+	key     string
+	keyName string
+	store   LimiterStore
+	local   *MemoryLimiterStore
+}
+
+// NewRateLimitMiddleware allows burst requests at once per client IP, refilled
+// at rate requests per second.
+func NewRateLimitMiddleware(rate float64, burst int) (*RateLimitMiddleware, error) {
+	if rate <= 0 || math.IsInf(rate, 0) {
+		return nil, fmt.Errorf("invalid rate %v, must be positive", rate)
+	}
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &RateLimitMiddleware{
+		limit: RateLimit{Rate: rate, Burst: burst},
+		key:   RateLimitKeyIP,
+		local: NewMemoryLimiterStore(),
+	}, nil
+}
+
+// WithStore sets the store of the token buckets, e.g. one shared by the
+// gateway instances.
+func (m *RateLimitMiddleware) WithStore(store LimiterStore) *RateLimitMiddleware {
+	m.store = store
+	return m
+}
+
+// WithKey sets what the requests are limited by. The name is the header for
+// RateLimitKeyHeader, and the claim for RateLimitKeyJWTClaim, whose token is
+// taken from the Authorization header without verifying it. Both must be
+// authenticated by a filter applied before the rate limit.
+func (m *RateLimitMiddleware) WithKey(key, name string) (*RateLimitMiddleware, error) {
+	switch key {
+	case "", RateLimitKeyIP, RateLimitKeyRoute, RateLimitKeyConsumer:
+		name = ""
+	case RateLimitKeyHeader:
+		if name == "" {
+			name = DefaultRateLimitHeader
+		}
+		name = http.CanonicalHeaderKey(name)
+	case RateLimitKeyJWTClaim:
+		if name == "" {
+			name = DefaultRateLimitClaim
+		}
+	default:
+		return nil, fmt.Errorf(ErrPatternUnknownRateLimitKey, key)
+	}
+	if key == "" {
+		key = RateLimitKeyIP
+	}
+	m.key, m.keyName = key, name
+	return m, nil
+}
+
+type rateLimitParams struct {
+	Key    string               `yaml:"key"`
+	Header string               `yaml:"header"`
+	Claim  string               `yaml:"claim"`
+	Rate   float64              `yaml:"rate"`
+	Period time.Duration        `yaml:"period"`
+	Burst  int                  `yaml:"burst"`
+	Store  rateLimitStoreParams `yaml:"store"`
+}
+
+type rateLimitStoreParams struct {
+	Type          string        `yaml:"type"`
+	Address       string        `yaml:"address"`
+	Password      string        `yaml:"password"`
+	DB            int           `yaml:"db"`
+	Prefix        string        `yaml:"prefix"`
+	Timeout       time.Duration `yaml:"timeout"`
+	PoolSize      int           `yaml:"pool_size"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+func newRateLimitMiddlewareFromParams(params Params) (Middleware, error) {
+	p := rateLimitParams{Period: time.Second}
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Period <= 0 {
+		return nil, fmt.Errorf("invalid period %v, must be positive", p.Period)
+	}
+
+	m, err := NewRateLimitMiddleware(p.Rate/p.Period.Seconds(), p.Burst)
+	if err != nil {
+		return nil, err
+	}
+	switch p.Store.Type {
+	case "", LimiterStoreMemory:
+	case LimiterStoreRedis:
+		m.WithStore(NewRedisLimiterStore(p.Store.Address).
+			WithPassword(p.Store.Password).
+			WithDB(p.Store.DB).
+			WithPrefix(p.Store.Prefix).
+			WithTimeout(p.Store.Timeout).
+			WithPoolSize(p.Store.PoolSize).
+			WithRetryInterval(p.Store.RetryInterval))
+	default:
+		return nil, fmt.Errorf("unknown rate limit store '%s'", p.Store.Type)
+	}
+
+	name := p.Header
+	if p.Key == RateLimitKeyJWTClaim {
+		name = p.Claim
+	}
+	return m.WithKey(p.Key, name)
+}
+
+func (m *RateLimitMiddleware) Name() string {
+	return RateLimitMiddlewareName
+}
+
+func (m *RateLimitMiddleware) Priority() int {
+	return PriorityRateLimitMiddleware
+}
+
+// Close closes the store, if it holds connections.
+func (m *RateLimitMiddleware) Close() error {
+	if c, ok := m.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (m *RateLimitMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := m.take(r)
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(m.limit.Burst))
+		header.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+		if d.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gatewayRateLimitedRequests.WithLabelValues(routeTemplate(r), m.key).Inc()
+		header.Set("Retry-After", ceilSeconds(d.RetryAfter))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	}
+}
+
+func (m *RateLimitMiddleware) take(r *http.Request) RateLimitDecision {
+	key := m.requestKey(r)
+	if m.store != nil {
+		d, err := m.store.Take(r.Context(), key, m.limit)
+		if err == nil {
+			return d
+		}
+		gatewayRateLimitStoreFallbacks.Inc()
+	}
+	d, _ := m.local.Take(r.Context(), key, m.limit)
+	return d
+}
+
+// requestKey returns the key the request is limited by, prefixed by the kind
+// of key so that e.g. an API key cannot collide with an IP.
+func (m *RateLimitMiddleware) requestKey(r *http.Request) string {
+	switch m.key {
+	case RateLimitKeyHeader:
+		if value := r.Header.Get(m.keyName); value != "" {
+			return "header:" + value
+		}
+	case RateLimitKeyJWTClaim:
+		if value := jwtClaim(r, m.keyName); value != "" {
+			return "claim:" + value
+		}
+	case RateLimitKeyRoute:
+		if route := routeTemplate(r); route != "" {
+			return "route:" + route
+		}
+		return "route:" + r.URL.Path
+	case RateLimitKeyConsumer:
+		if c := ConsumerFromContext(r.Context()); c != nil {
+			return "consumer:" + c.Id
+		}
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// jwtClaim returns the claim of the bearer token of the request, without
+// verifying the token.
+func jwtClaim(r *http.Request, claim string) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	parts := strings.Split(auth[7:], ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	switch value := claims[claim].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRateLimit(t *testing.T, rate float64, burst int, key, name string) (*RateLimitMiddleware, *time.Time) {
+	m, err := NewRateLimitMiddleware(rate, burst)
+	if err == nil {
+		m, err = m.WithKey(key, name)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)
+	m.local.now = func() time.Time { return now }
+	return m, &now
+}
+
+func serveRateLimited(m *RateLimitMiddleware, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})(w, r)
+	return w
+}
+
+func TestRateLimitMiddleware_TokenBucket(t *testing.T) {
+	m, now := newTestRateLimit(t, 2, 3, RateLimitKeyIP, "")
+
+	steps := []struct {
+		advance   time.Duration
+		status    int
+		remaining string
+	}{
+		{0, http.StatusOK, "2"},
+		{0, http.StatusOK, "1"},
+		{0, http.StatusOK, "0"},
+		{0, http.StatusTooManyRequests, "0"},
+		{250 * time.Millisecond, http.StatusTooManyRequests, "0"},
+		{250 * time.Millisecond, http.StatusOK, "0"},
+		{10 * time.Second, http.StatusOK, "2"},
+	}
+
+	for i, step := range steps {
+		*now = now.Add(step.advance)
+		w := serveRateLimited(m, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != step.status {
+			t.Errorf("step %d: invalid status, expected: %d, actual: %d", i, step.status, w.Code)
+		}
+		if actual := w.Header().Get("RateLimit-Remaining"); actual != step.remaining {
+			t.Errorf("step %d: invalid RateLimit-Remaining, expected: %s, actual: %s", i, step.remaining, actual)
+		}
+		if w.Header().Get("RateLimit-Limit") != "3" {
+			t.Errorf("step %d: invalid RateLimit-Limit: %s", i, w.Header().Get("RateLimit-Limit"))
+		}
+		if step.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("step %d: invalid Retry-After: '%s'", i, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func testJWT(claims string) string {
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func TestRateLimitMiddleware_Keys(t *testing.T) {
+	tests := []struct {
+		key      string
+		name     string
+		prepare  func(r *http.Request, i int)
+		expected string
+	}{
+		{
+			key:      RateLimitKeyIP,
+			prepare:  func(r *http.Request, i int) { r.RemoteAddr = "192.0.2.1:" + strconv.Itoa(4000+i) },
+			expected: "ip:192.0.2.1",
+		},
+		{
+			key:      RateLimitKeyHeader,
+			prepare:  func(r *http.Request, i int) { r.Header.Set("X-Api-Key", "key-1") },
+			expected: "header:key-1",
+		},
+		{
+			key:      RateLimitKeyHeader,
+			name:     "x-client",
+			prepare:  func(r *http.Request, i int) {},
+			expected: "ip:192.0.2.1",
+		},
+		{
+			key:  RateLimitKeyJWTClaim,
+			name: "tenant",
+			prepare: func(r *http.Request, i int) {
+				r.Header.Set("Authorization", testJWT(`{"sub":"alice","tenant":"acme"}`))
+			},
+			expected: "claim:acme",
+		},
+		{
+			key:      RateLimitKeyJWTClaim,
+			prepare:  func(r *http.Request, i int) { r.Header.Set("Authorization", "Bearer invalid") },
+			expected: "ip:192.0.2.1",
+		},
+		{
+			key:      RateLimitKeyRoute,
+			prepare:  func(r *http.Request, i int) {},
+			expected: "route:/users/42",
+		},
+	}
+
+	for _, tt := range tests {
+		m, _ := newTestRateLimit(t, 1, 1, tt.key, tt.name)
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+			r.RemoteAddr = "192.0.2.1:4711"
+			tt.prepare(r, i)
+			if actual := m.requestKey(r); actual != tt.expected {
+				t.Errorf("%s %s: invalid key, expected: '%s', actual: '%s'", tt.key, tt.name, tt.expected, actual)
+			}
+		}
+	}
+}
+
+func TestRateLimitMiddleware_ResolvedClientIP(t *testing.T) {
+	m, _ := newTestRateLimit(t, 1, 1, RateLimitKeyIP, "")
+
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+		r.RemoteAddr = "10.0.0.1:4711"
+		r = WithClientIP(r, ip)
+
+		if actual := m.requestKey(r); actual != "ip:"+ip {
+			t.Errorf("invalid key, expected: '%s', actual: '%s'", "ip:"+ip, actual)
+		}
+	}
+}
+
+func TestRateLimitMiddleware_RouteTemplate(t *testing.T) {
+	m, _ := newTestRateLimit(t, 1, 1, RateLimitKeyRoute, "")
+	r, _ := withRequestInfo(httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	SetRouteTemplate(r, "/users/:id")
+
+	if actual := m.requestKey(r); actual != "route:/users/:id" {
+		t.Errorf("invalid key, expected: '%s', actual: '%s'", "route:/users/:id", actual)
+	}
+}
+
+func TestRateLimitMiddleware_Params(t *testing.T) {
+	tests := []struct {
+		params  Params
+		isError bool
+	}{
+		{params: Params{"rate": 100, "period": "1m", "burst": 10, "key": "header", "header": "X-Client-Id"}},
+		{params: Params{"rate": 5, "key": "jwt_claim", "claim": "tenant"}},
+		{params: Params{"rate": 0}, isError: true},
+		{params: Params{"rate": 5, "key": "cookie"}, isError: true},
+		{params: Params{"rate": 5, "period": "-1s"}, isError: true},
+	}
+
+	for _, tt := range tests {
+		_, err := New(RateLimitMiddlewareName, tt.params)
+		if tt.isError && err == nil {
+			t.Errorf("%v: expected error, none occurred", tt.params)
+		}
+		if !tt.isError && err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.params, err)
+		}
+	}
+}
+
+func TestMemoryLimiterStore_Concurrent(t *testing.T) {
+	store := NewMemoryLimiterStore()
+	limit := RateLimit{Rate: 0.001, Burst: 100}
+
+	var wg sync.WaitGroup
+	allowed := make(chan bool, 400)
+	for i := 0; i < 400; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, _ := store.Take(context.Background(), "client-"+strconv.Itoa(i%2), limit)
+			allowed <- d.Allowed
+		}(i)
+	}
+	wg.Wait()
+	close(allowed)
+
+	n := 0
+	for ok := range allowed {
+		if ok {
+			n++
+		}
+	}
+	if n != 200 {
+		t.Errorf("invalid number of allowed requests, expected: %d, actual: %d", 200, n)
+	}
+}
+
+func TestMemoryLimiterStore_Sweep(t *testing.T) {
+	store := NewMemoryLimiterStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	store.Take(ctx, "idle", limit)
+	store.Take(ctx, "busy", limit)
+	store.Take(ctx, "busy", limit)
+	now = now.Add(1500 * time.Millisecond)
+
+	buckets := map[string]bool{}
+	for i := range store.shards {
+		store.shards[i].sweep(now, limit)
+		for key := range store.shards[i].buckets {
+			buckets[key] = true
+		}
+	}
+	if buckets["idle"] || !buckets["busy"] {
+		t.Errorf("invalid buckets after sweep, expected only 'busy', actual: %v", buckets)
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitShards = 64
+	// rateLimitSweepInterval is how often a shard removes the buckets which
+	// refilled completely, as they are no different from new buckets.
+	rateLimitSweepInterval = time.Minute
+)
+
+// RateLimit allows Burst requests at once, refilled at Rate per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitDecision is the outcome of taking a token for a request.
+type RateLimitDecision struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is the time until the next token is available, when the
+	// request is rejected.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// LimiterStore keeps the rate limit state of the keys. Stores shared between
+// gateway instances enforce a limit across all of them.
+type LimiterStore interface {
+	// Take takes a token of the key if one is available.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitDecision, error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket for the time elapsed since its last update and
+// takes a token if one is available.
+func (b *tokenBucket) take(now time.Time, limit RateLimit) RateLimitDecision {
+	burst := float64(limit.Burst)
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	d := RateLimitDecision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = secondsDuration((1 - b.tokens) / limit.Rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = secondsDuration((burst - b.tokens) / limit.Rate)
+	return d
+}
+
+func (b *tokenBucket) full(now time.Time, limit RateLimit) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*limit.Rate >= float64(limit.Burst)
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// MemoryLimiterStore keeps the token buckets in memory, limiting each gateway
+// instance on its own. The keys are spread over shards with a lock each, so
+// that concurrent requests of different clients rarely contend.
+type MemoryLimiterStore struct {
+	shards [rateLimitShards]rateLimitShard
+	now    func() time.Time
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	s := &MemoryLimiterStore{now: time.Now}
+	for i := range s.shards {
+		s.shards[i].buckets = map[string]*tokenBucket{}
+	}
+	return s
+}
+
+func (s *MemoryLimiterStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitDecision, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.Sub(shard.lastSweep) >= rateLimitSweepInterval {
+		shard.sweep(now, limit)
+	}
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		shard.buckets[key] = b
+	}
+	return b.take(now, limit), nil
+}
+
+func (s *rateLimitShard) sweep(now time.Time, limit RateLimit) {
+	for key, b := range s.buckets {
+		if b.full(now, limit) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply of the server, after which the connection
+// remains usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+var errRedisClientClosed = errors.New("redis client closed")
+
+// redisClient is a minimal client of the Redis protocol (RESP2) with a pool of
+// connections, covering the commands of the RedisLimiterStore.
+type redisClient struct {
+	address  string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	size   int
+	closed bool
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// do sends the command and returns its reply: a string, an int64, a []byte
+// or nil for bulk strings, or a []interface{} of replies.
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	rc, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.do(ctx, c.timeout, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		rc.conn.Close()
+		return nil, err
+	}
+	c.put(rc)
+	return reply, err
+}
+
+func (c *redisClient) get(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errRedisClientClosed
+	}
+	if n := len(c.idle); n > 0 {
+		rc := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return rc, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	if c.password != "" {
+		if _, err := rc.do(ctx, c.timeout, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis authentication failed: %v", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do(ctx, c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// put returns the connection to the pool, closing it if the pool is full.
+func (c *redisClient) put(rc *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= c.size {
+		rc.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, rc)
+}
+
+func (c *redisClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, rc := range c.idle {
+		rc.conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (rc *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := rc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	rc.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		rc.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRedisReply(rc.r)
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			// Error replies within arrays are returned as values.
+			if replies[i], err = readRedisReply(r); err != nil {
+				if e, ok := err.(redisError); ok {
+					replies[i] = e
+					continue
+				}
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %q", line)
+}
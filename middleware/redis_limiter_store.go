@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LimiterStoreMemory = "memory"
+	LimiterStoreRedis  = "redis"
+
+	DefaultRedisLimiterPrefix = "api-gw:ratelimit:"
+)
+
+var errRedisUnavailable = errors.New("redis limiter store unavailable")
+
+// gcraScript implements the token bucket as a generic cell rate algorithm,
+// storing only the theoretical arrival time (TAT) of the next request in
+// microseconds. The time of the server is used, so that the clocks of the
+// gateway instances do not matter.
+//
+// KEYS[1] is the key, ARGV[1] the emission interval in microseconds and
+// ARGV[2] the burst. It returns whether the request is allowed, the remaining
+// requests, and the retry after and reset times in microseconds.
+const gcraScript = `
+redis.replicate_commands()
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+  tat = now
+end
+local new_tat = tat + emission
+local diff = now - (new_tat - emission * burst)
+if diff < 0 then
+  return {0, 0, string.format('%.0f', -diff), string.format('%.0f', tat - now)}
+end
+redis.call('SET', KEYS[1], string.format('%.0f', new_tat), 'PX', math.ceil((new_tat - now) / 1000))
+return {1, math.floor(diff / emission), 0, string.format('%.0f', new_tat - now)}
+`
+
+var gcraScriptSHA = func() string {
+	sum := sha1.Sum([]byte(gcraScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisLimiterStore keeps the rate limit state in Redis, or a server speaking
+// its protocol, so that the limits hold across gateway instances. Once a
+// request to the server fails, the store reports errors without contacting
+// the server until the retry interval elapsed.
+type RedisLimiterStore struct {
+	client        *redisClient
+	prefix        string
+	retryInterval time.Duration
+
+	mu               sync.Mutex
+	unavailableUntil time.Time
+	now              func() time.Time
+}
+
+func NewRedisLimiterStore(address string) *RedisLimiterStore {
+	return &RedisLimiterStore{
+		client: &redisClient{
+			address: address,
+			timeout: 100 * time.Millisecond,
+			size:    16,
+		},
+		prefix:        DefaultRedisLimiterPrefix,
+		retryInterval: 5 * time.Second,
+		now:           time.Now,
+	}
+}
+
+func (s *RedisLimiterStore) WithPassword(password string) *RedisLimiterStore {
+	s.client.password = password
+	return s
+}
+
+func (s *RedisLimiterStore) WithDB(db int) *RedisLimiterStore {
+	s.client.db = db
+	return s
+}
+
+// WithPrefix sets the prefix of the keys. Rate limits using the same prefix
+// share their token buckets.
+func (s *RedisLimiterStore) WithPrefix(prefix string) *RedisLimiterStore {
+	if prefix != "" {
+		s.prefix = prefix
+	}
+	return s
+}
+
+// WithTimeout sets the timeout of connecting and of each command.
+func (s *RedisLimiterStore) WithTimeout(timeout time.Duration) *RedisLimiterStore {
+	if timeout > 0 {
+		s.client.timeout = timeout
+	}
+	return s
+}
+
+// WithPoolSize sets the maximum number of idle connections.
+func (s *RedisLimiterStore) WithPoolSize(size int) *RedisLimiterStore {
+	if size > 0 {
+		s.client.size = size
+	}
+	return s
+}
+
+func (s *RedisLimiterStore) WithRetryInterval(retryInterval time.Duration) *RedisLimiterStore {
+	if retryInterval > 0 {
+		s.retryInterval = retryInterval
+	}
+	return s
+}
+
+// Take takes a token of the key. The key is hashed, so that e.g. API keys are
+// not stored in the clear.
+func (s *RedisLimiterStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitDecision, error) {
+	if !s.available() {
+		return RateLimitDecision{}, errRedisUnavailable
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	args := []string{
+		s.prefix + hex.EncodeToString(sum[:16]),
+		strconv.FormatFloat(1e6/limit.Rate, 'f', 3, 64),
+		strconv.Itoa(limit.Burst),
+	}
+	reply, err := s.client.do(ctx, append([]string{"EVALSHA", gcraScriptSHA, "1"}, args...)...)
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		reply, err = s.client.do(ctx, append([]string{"EVAL", gcraScript, "1"}, args...)...)
+	}
+
+	var d RateLimitDecision
+	if err == nil {
+		d, err = parseGCRAReply(reply)
+	}
+	if err != nil {
+		// A request canceled by its client says nothing about the server.
+		if ctx.Err() == nil {
+			s.failed(err)
+		}
+		return RateLimitDecision{}, err
+	}
+	s.succeeded()
+	return d, nil
+}
+
+func (s *RedisLimiterStore) Close() error {
+	return s.client.close()
+}
+
+func (s *RedisLimiterStore) available() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unavailableUntil.IsZero() || !s.now().Before(s.unavailableUntil)
+}
+
+func (s *RedisLimiterStore) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unavailableUntil.IsZero() {
+		logger().Warnf("rate limit store %s unavailable, limiting locally: %v", s.client.address, err)
+	}
+	s.unavailableUntil = s.now().Add(s.retryInterval)
+}
+
+func (s *RedisLimiterStore) succeeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.unavailableUntil.IsZero() {
+		logger().Infof("rate limit store %s available again", s.client.address)
+		s.unavailableUntil = time.Time{}
+	}
+}
+
+func parseGCRAReply(reply interface{}) (RateLimitDecision, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 4 {
+		return RateLimitDecision{}, fmt.Errorf("invalid reply of rate limit script: %v", reply)
+	}
+
+	var n [4]int64
+	for i, value := range values {
+		switch v := value.(type) {
+		case int64:
+			n[i] = v
+		case []byte:
+			parsed, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return RateLimitDecision{}, fmt.Errorf("invalid reply of rate limit script: %v", reply)
+			}
+			n[i] = parsed
+		default:
+			return RateLimitDecision{}, fmt.Errorf("invalid reply of rate limit script: %v", reply)
+		}
+	}
+
+	return RateLimitDecision{
+		Allowed:    n[0] == 1,
+		Remaining:  int(n[1]),
+		RetryAfter: time.Duration(n[2]) * time.Microsecond,
+		Reset:      time.Duration(n[3]) * time.Microsecond,
+	}, nil
+}
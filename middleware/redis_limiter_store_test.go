@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for Redis, speaking enough of its
+// protocol for the RedisLimiterStore. The rate limit script is emulated.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	values   map[string]string
+	scripts  map[string]bool
+	commands []string
+	now      time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{
+		listener: listener,
+		password: password,
+		values:   map[string]string{},
+		scripts:  map[string]bool{},
+		now:      time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	go r.serve()
+	t.Cleanup(func() { listener.Close() })
+	return r
+}
+
+func (r *fakeRedis) addr() string {
+	return r.listener.Addr().String()
+}
+
+func (r *fakeRedis) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = r.now.Add(d)
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.serveConn(conn)
+	}
+}
+
+func (r *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	authenticated := r.password == ""
+
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		values, _ := reply.([]interface{})
+		args := make([]string, len(values))
+		for i, v := range values {
+			args[i] = string(v.([]byte))
+		}
+
+		if len(args) > 0 && args[0] == "AUTH" {
+			authenticated = len(args) == 2 && args[1] == r.password
+		}
+		if !authenticated {
+			writer.WriteString("-NOAUTH Authentication required.\r\n")
+		} else {
+			writer.WriteString(r.command(args))
+		}
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (r *fakeRedis) command(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, args[0])
+
+	switch args[0] {
+	case "AUTH", "SELECT", "PING":
+		return "+OK\r\n"
+	case "EVALSHA":
+		if !r.scripts[args[1]] {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+	case "EVAL":
+		if args[1] != gcraScript {
+			return "-ERR unknown script\r\n"
+		}
+		r.scripts[gcraScriptSHA] = true
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+	return r.gcra(args[3], args[4], args[5])
+}
+
+// gcra emulates gcraScript.
+func (r *fakeRedis) gcra(key, emissionArg, burstArg string) string {
+	emission, _ := strconv.ParseFloat(emissionArg, 64)
+	burst, _ := strconv.ParseFloat(burstArg, 64)
+	now := float64(r.now.UnixNano() / 1000)
+
+	tat, err := strconv.ParseFloat(r.values[key], 64)
+	if err != nil || tat < now {
+		tat = now
+	}
+	newTat := tat + emission
+	diff := now - (newTat - emission*burst)
+	if diff < 0 {
+		return fmt.Sprintf("*4\r\n:0\r\n:0\r\n:%.0f\r\n:%.0f\r\n", -diff, tat-now)
+	}
+	r.values[key] = fmt.Sprintf("%.0f", newTat)
+	return fmt.Sprintf("*4\r\n:1\r\n:%.0f\r\n:0\r\n:%.0f\r\n", math.Floor(diff/emission), newTat-now)
+}
+
+func TestRedisLimiterStore_SharedLimit(t *testing.T) {
+	redis := newFakeRedis(t, "")
+	limit := RateLimit{Rate: 1, Burst: 3}
+	ctx := context.Background()
+
+	// Two gateway instances share the limit of the client.
+	first := NewRedisLimiterStore(redis.addr())
+	defer first.Close()
+	second := NewRedisLimiterStore(redis.addr())
+	defer second.Close()
+
+	steps := []struct {
+		store     *RedisLimiterStore
+		advance   time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{first, 0, true, 2},
+		{second, 0, true, 1},
+		{first, 0, true, 0},
+		{second, 0, false, 0},
+		{first, time.Second, true, 0},
+		{second, 0, false, 0},
+	}
+
+	for i, step := range steps {
+		redis.advance(step.advance)
+		d, err := step.store.Take(ctx, "ip:192.0.2.1", limit)
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if d.Allowed != step.allowed || d.Remaining != step.remaining {
+			t.Errorf("step %d: invalid decision, expected: %v %d, actual: %+v", i, step.allowed, step.remaining, d)
+		}
+		if !d.Allowed && d.RetryAfter != time.Second {
+			t.Errorf("step %d: invalid retry after: %v", i, d.RetryAfter)
+		}
+	}
+
+	// The script is loaded once, and run by its SHA afterwards.
+	expected := []string{"EVALSHA", "EVAL", "EVALSHA", "EVALSHA"}
+	for i, command := range expected {
+		if redis.commands[i] != command {
+			t.Errorf("invalid commands, expected to start with: %v, actual: %v", expected, redis.commands)
+			break
+		}
+	}
+}
+
+func TestRedisLimiterStore_Password(t *testing.T) {
+	redis := newFakeRedis(t, "secret")
+	limit := RateLimit{Rate: 1, Burst: 1}
+
+	store := NewRedisLimiterStore(redis.addr()).WithPassword("secret").WithDB(2)
+	defer store.Close()
+	if _, err := store.Take(context.Background(), "key", limit); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	wrong := NewRedisLimiterStore(redis.addr()).WithPassword("wrong")
+	defer wrong.Close()
+	if _, err := wrong.Take(context.Background(), "key", limit); err == nil {
+		t.Error("expected error of wrong password, none occurred")
+	}
+}
+
+func TestRedisLimiterStore_Unavailable(t *testing.T) {
+	redis := newFakeRedis(t, "")
+	addr := redis.addr()
+	redis.listener.Close()
+
+	store := NewRedisLimiterStore(addr).WithRetryInterval(time.Minute)
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 1, Burst: 1}
+
+	if _, err := store.Take(context.Background(), "key", limit); err == nil {
+		t.Fatal("expected error of unreachable store, none occurred")
+	}
+	if _, err := store.Take(context.Background(), "key", limit); err != errRedisUnavailable {
+		t.Errorf("expected store to be skipped until the retry interval elapsed, error: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if !store.available() {
+		t.Error("expected store to be retried after the retry interval")
+	}
+}
+
+func TestRateLimitMiddleware_StoreFallback(t *testing.T) {
+	redis := newFakeRedis(t, "")
+	addr := redis.addr()
+	redis.listener.Close()
+
+	m, err := New(RateLimitMiddlewareName, Params{
+		"rate":  1,
+		"burst": 2,
+		"store": map[string]interface{}{"type": "redis", "address": addr},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer Close(m)
+
+	handler := m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {})
+	var statuses []int
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		statuses = append(statuses, w.Code)
+	}
+
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("invalid statuses of local fallback, expected: %v, actual: %v", expected, statuses)
+			break
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrPatternUnknownMiddleware = "unknown middleware '%s'"
+
+// Params are the parameters of a middleware in the configuration.
+type Params map[string]interface{}
+
+// Decode decodes the parameters into v, a pointer to a struct with yaml tags.
+func (p Params) Decode(v interface{}) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(data, v)
+}
+
+// Factory builds a middleware from its parameters, typically decoding them
+// with Params.Decode.
+type Factory func(params Params) (Middleware, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{
+		HeadersMiddlewareName:          newHeadersMiddlewareFromParams,
+		CorsMiddlewareName:             newCorsMiddlewareFromParams,
+		RateLimitMiddlewareName:        newRateLimitMiddlewareFromParams,
+		ConcurrencyLimitMiddlewareName: newConcurrencyLimitMiddlewareFromParams,
+		PriorityClassMiddlewareName:    newPriorityClassMiddlewareFromParams,
+		KeyAuthMiddlewareName:          newKeyAuthMiddlewareFromParams,
+	}
+)
+
+// RegisterMiddleware makes a middleware available under the name, for use in
+// the filters of the configuration. It is meant to be called from the init
+// function of the package providing the middleware, and panics if the name is
+// already registered.
+func RegisterMiddleware(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("middleware: factory of '" + name + "' is nil")
+	}
+	if _, ok := factories[name]; ok {
+		panic("middleware: '" + name + "' is already registered")
+	}
+	factories[name] = factory
+}
+
+// New builds the middleware registered under the name, and initializes it if
+// it implements Initializer.
+func New(name string, params Params) (Middleware, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(ErrPatternUnknownMiddleware, name)
+	}
+
+	m, err := factory(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters of middleware '%s': %v", name, err)
+	}
+	if m.Name() != name {
+		return nil, fmt.Errorf("middleware '%s' registered as '%s'", m.Name(), name)
+	}
+	if i, ok := m.(Initializer); ok {
+		if err := i.Init(); err != nil {
+			return nil, fmt.Errorf("failed to initialize middleware '%s': %v", m.Name(), err)
+		}
+	}
+	logger().Debugf("built middleware '%s' with priority %d", m.Name(), m.Priority())
+	return m, nil
+}
+
+// Close closes the middlewares implementing Closer.
+func Close(middlewares ...Middleware) {
+	for _, m := range middlewares {
+		if c, ok := m.(Closer); ok {
+			if err := c.Close(); err != nil {
+				logger().Warnf("failed to close middleware '%s': %v", m.Name(), err)
+			}
+		}
+	}
+}
+
+// Names returns the names of the registered middlewares.
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain applies the middlewares in the given order, the first being the
+// outermost, unlike Compose which orders them by priority.
+func Chain(middlewares ...Middleware) FilterFunctionAdaptor {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i].FilterFunction(next)
+		}
+		return next
+	}
+}
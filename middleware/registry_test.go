@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		isError bool
+	}{
+		{name: HeadersMiddlewareName, params: Params{"request": map[interface{}]interface{}{"remove": []interface{}{"Cookie"}}}},
+		{name: CorsMiddlewareName, params: Params{"allowed_origins": []interface{}{"*"}, "max_age": "10m"}},
+		{name: CorsMiddlewareName},
+		{name: CorsMiddlewareName, params: Params{"allowed_origin": []interface{}{"*"}}, isError: true},
+		{name: "unknown", isError: true},
+	}
+
+	for _, tt := range tests {
+		_, err := New(tt.name, tt.params)
+		if tt.isError && err == nil {
+			t.Errorf("%s %v: expected error, none occurred", tt.name, tt.params)
+		}
+		if !tt.isError && err != nil {
+			t.Errorf("%s %v: unexpected error: %v", tt.name, tt.params, err)
+		}
+	}
+}
+
+func TestNew_NameMismatch(t *testing.T) {
+	RegisterMiddleware("headers_alias", newHeadersMiddlewareFromParams)
+
+	if _, err := New("headers_alias", nil); err == nil {
+		t.Error("expected error for middleware named apart from its registration, none occurred")
+	}
+}
+
+func TestChain_Order(t *testing.T) {
+	var order []string
+	first, _ := New(HeadersMiddlewareName, Params{"request": map[string]interface{}{"set": map[string]string{"X-Step": "first"}}})
+	second, _ := New(HeadersMiddlewareName, Params{"request": map[string]interface{}{"set": map[string]string{"X-Step": "second"}}})
+
+	handler := Chain(first, second)(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, r.Header.Get("X-Step"))
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if len(order) != 1 || order[0] != "second" {
+		t.Errorf("invalid order of middlewares, expected the last to set X-Step, actual: %v", order)
+	}
+}
+
+func TestHeadersMiddleware(t *testing.T) {
+	m := NewHeadersMiddleware().
+		WithRequestHeaders(HeaderRules{Set: map[string]string{"X-Gateway": "api-gw"}, Remove: []string{"Cookie"}}).
+		WithResponseHeaders(HeaderRules{Set: map[string]string{"Cache-Control": "no-store"}, Remove: []string{"Server"}})
+
+	var request http.Header
+	handler := m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+		request = r.Header.Clone()
+		w.Header().Set("Server", "backend")
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "session=secret")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if request.Get("X-Gateway") != "api-gw" || request.Get("Cookie") != "" {
+		t.Errorf("invalid request headers: %v", request)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Server") != "" {
+		t.Errorf("invalid response headers: %v", w.Header())
+	}
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	RequestIdMiddlewareName = "request_id"
+
+	DefaultRequestIdHeader = "X-Request-Id"
+
+	RequestIdFormatUUID = "uuid"
+	RequestIdFormatULID = "ulid"
+
+	// maxRequestIdLength bounds accepted request IDs, longer IDs are replaced.
+	maxRequestIdLength = 128
+)
+
+// RequestIdMiddleware accepts the request ID sent by the client, or generates
+// one, and passes it on to the backends and back to the client.
+type RequestIdMiddleware struct {
+	header   string
+	generate func() string
+}
+
+func NewRequestIdMiddleware() *RequestIdMiddleware {
+	return &RequestIdMiddleware{
+		header:   DefaultRequestIdHeader,
+		generate: newUUID,
+	}
+}
+
+func (m *RequestIdMiddleware) WithHeader(header string) *RequestIdMiddleware {
+	if header != "" {
+		m.header = http.CanonicalHeaderKey(header)
+	}
+	return m
+}
+
+func (m *RequestIdMiddleware) WithFormat(format string) (*RequestIdMiddleware, error) {
+	switch format {
+	case "", RequestIdFormatUUID:
+		m.generate = newUUID
+	case RequestIdFormatULID:
+		m.generate = newULID
+	default:
+		return nil, fmt.Errorf("unknown request id format '%s'", format)
+	}
+	return m, nil
+}
+
+func (m *RequestIdMiddleware) Name() string {
+	return RequestIdMiddlewareName
+}
+
+func (m *RequestIdMiddleware) Priority() int {
+	return PriorityRequestIdMiddleware
+}
+
+func (m *RequestIdMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(m.header)
+		if !validRequestId(id) {
+			id = m.generate()
+		}
+		r.Header.Set(m.header, id)
+
+		r = r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id))
+		next.ServeHTTP(&requestIdResponseWriter{ResponseWriter: w, header: m.header, id: id}, r)
+	}
+}
+
+type requestIdKey struct{}
+
+// RequestIdFromContext returns the request ID of the request, or an empty
+// string if the RequestIdMiddleware is not in use.
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIdResponseWriter sets the request ID header of the response when the
+// header is written, replacing any request ID echoed by the backend.
+type requestIdResponseWriter struct {
+	http.ResponseWriter
+	header      string
+	id          string
+	wroteHeader bool
+}
+
+func (w *requestIdResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(w.header, w.id)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *requestIdResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *requestIdResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID, a lexicographically sortable ID made of a
+// millisecond timestamp and 80 random bits.
+func newULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	_, _ = rand.Read(b[6:])
+
+	// 128 bits are encoded into 26 characters of 5 bits, the first character
+	// carrying the 3 most significant bits.
+	var s [26]byte
+	hi := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+		uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+	lo := uint64(b[8])<<56 | uint64(b[9])<<48 | uint64(b[10])<<40 | uint64(b[11])<<32 |
+		uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])
+	for i := 25; i >= 0; i-- {
+		s[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
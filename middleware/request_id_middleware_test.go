@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func serveRequestId(m *RequestIdMiddleware, requestId string) (forwarded, echoed, fromContext string) {
+	handler := m.FilterFunction(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(m.header)
+		fromContext = RequestIdFromContext(r.Context())
+		w.Header().Set(m.header, "backend-id")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if requestId != "" {
+		req.Header.Set(m.header, requestId)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return forwarded, w.Header().Get(m.header), fromContext
+}
+
+func TestRequestIdMiddleware(t *testing.T) {
+	ulid, _ := NewRequestIdMiddleware().WithHeader("x-correlation-id").WithFormat(RequestIdFormatULID)
+
+	tests := []struct {
+		name       string
+		middleware *RequestIdMiddleware
+		requestId  string
+		pattern    *regexp.Regexp
+	}{
+		{"generated uuid", NewRequestIdMiddleware(), "", uuidPattern},
+		{"generated ulid", ulid, "", ulidPattern},
+		{"accepted", NewRequestIdMiddleware(), "client-id-42", regexp.MustCompile(`^client-id-42$`)},
+		{"replaced invalid", NewRequestIdMiddleware(), "client id", uuidPattern},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded, echoed, fromContext := serveRequestId(tt.middleware, tt.requestId)
+			if !tt.pattern.MatchString(forwarded) {
+				t.Errorf("invalid request id: '%s'", forwarded)
+			}
+			if echoed != forwarded || fromContext != forwarded {
+				t.Errorf("request id not propagated, forwarded: '%s', echoed: '%s', context: '%s'", forwarded, echoed, fromContext)
+			}
+		})
+	}
+
+	if _, err := NewRequestIdMiddleware().WithFormat("snowflake"); err == nil {
+		t.Error("expected error for unknown format, none occurred")
+	}
+}
+
+func TestNewULID_Sortable(t *testing.T) {
+	previous := newULID()
+	for i := 0; i < 10; i++ {
+		next := newULID()
+		if next[:10] < previous[:10] {
+			t.Fatalf("ulid timestamp not increasing: %s < %s", next, previous)
+		}
+		previous = next
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RouteTemplateNotFound is the route template of requests matching no route.
+const RouteTemplateNotFound = "NOT_FOUND"
+
+type requestInfoKey struct{}
+
+// requestInfo is carried in the request context so that the router and the
+// proxy, which run inside the middlewares, can report the matched route and
+// the upstream request back to them.
+type requestInfo struct {
+	route           string
+	upstream        string
+	upstreamLatency time.Duration
+	consumer        string
+	// uri replaces the request URI in the access log, e.g. without an API key.
+	uri string
+}
+
+// withRequestInfo returns the request with a request info to be filled in by
+// the router, which is shared with the middlewares wrapping this one.
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return r, ri
+	}
+	ri := &requestInfo{route: RouteTemplateNotFound}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, ri)), ri
+}
+
+// SetRouteTemplate records the path template of the route matching the
+// request, e.g. /users/:id.
+func SetRouteTemplate(r *http.Request, path string) {
+	if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok && path != "" {
+		ri.route = path
+	}
+}
+
+// SetUpstream records the address of the target the request was proxied to,
+// and the latency of the upstream request until its response headers arrived.
+// With retries the last attempt is recorded.
+func SetUpstream(r *http.Request, address string, latency time.Duration) {
+	if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		ri.upstream, ri.upstreamLatency = address, latency
+	}
+}
+
+// upstreamLatency returns the upstream latency recorded for the request of the
+// context, or 0 if the request was not proxied yet.
+func upstreamLatency(ctx context.Context) time.Duration {
+	if ri, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return ri.upstreamLatency
+	}
+	return 0
+}
+
+// routeTemplate returns the route template recorded for the request, or an
+// empty string before the request is matched to a route.
+func routeTemplate(r *http.Request) string {
+	if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok && ri.route != RouteTemplateNotFound {
+		return ri.route
+	}
+	return ""
+}
+
+type clientIPKey struct{}
+
+// WithClientIP returns the request with the IP of its client, resolved by the
+// gateway through the X-Forwarded-For header of trusted proxies.
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIP returns the IP of the client of the request, which is the remote
+// address of the request unless the gateway resolved it through its trusted
+// proxies.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
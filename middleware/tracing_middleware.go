@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cdmatta/api-gw/tracing"
+)
+
+const TracingMiddlewareName = "tracing"
+
+// TracingMiddleware starts the server span of every request, continuing the
+// trace of the caller. The proxy starts the client spans of the upstream
+// requests as children of this span.
+type TracingMiddleware struct {
+	tracer *tracing.Tracer
+}
+
+func NewTracingMiddleware(tracer *tracing.Tracer) *TracingMiddleware {
+	return &TracingMiddleware{tracer: tracer}
+}
+
+func (m *TracingMiddleware) Name() string {
+	return TracingMiddlewareName
+}
+
+func (m *TracingMiddleware) Priority() int {
+	return PriorityTracingMiddleware
+}
+
+func (m *TracingMiddleware) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := m.tracer.StartServerSpan(r, r.Method)
+		defer span.Finish()
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", r.RemoteAddr)
+		if id := RequestIdFromContext(ctx); id != "" {
+			span.SetAttribute("request.id", id)
+		}
+
+		r, ri := withRequestInfo(r.WithContext(ctx))
+		lrw := newLoggingResponseWriter(w)
+		next.ServeHTTP(lrw, r)
+
+		span.SetName(r.Method + " " + ri.route)
+		span.SetAttribute("http.route", ri.route)
+		span.SetAttribute("http.response.status_code", lrw.statusCode)
+		if lrw.statusCode >= http.StatusInternalServerError {
+			span.SetError(strconv.Itoa(lrw.statusCode) + " " + http.StatusText(lrw.statusCode))
+		}
+	}
+}
@@ -1,54 +1,424 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
 	"net/http/httputil"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cdmatta/api-gw/httprouter"
 	"github.com/cdmatta/api-gw/middleware"
+	"github.com/cdmatta/api-gw/tracing"
 )
 
 type ReverseProxy struct {
-	router           httprouter.Router
+	table            atomic.Value
+	tableMu          sync.Mutex
 	globalFilterFunc http.HandlerFunc
+	retryBudget      *RetryBudget
+	serverTimeouts   ServerTimeouts
+	trustedProxies   *TrustedProxies
 }
 
 func NewReverseProxy() *ReverseProxy {
-	return &ReverseProxy{}
+	r := &ReverseProxy{
+		retryBudget: NewRetryBudget(20, 10),
+		serverTimeouts: ServerTimeouts{
+			ReadHeader: 10 * time.Second,
+			Idle:       2 * time.Minute,
+		},
+	}
+	r.table.Store(&routeTable{router: &httprouter.Router{}})
+	r.globalFilterFunc = r.serveRoute
+	return r
 }
 
 func (r *ReverseProxy) WithGlobalFilterFunc(m middleware.FilterFunctionAdaptor) *ReverseProxy {
-	r.globalFilterFunc = m(func(w http.ResponseWriter, req *http.Request) {
-		r.router.ServeHTTP(w, req)
-	})
+	r.globalFilterFunc = m(r.serveRoute)
+	return r
+}
+
+func (r *ReverseProxy) WithRetryBudget(retryBudget *RetryBudget) *ReverseProxy {
+	r.retryBudget = retryBudget
+	return r
+}
+
+// WithServerTimeouts overrides the default server timeouts with the non-zero
+// values of the given timeouts.
+func (r *ReverseProxy) WithServerTimeouts(timeouts ServerTimeouts) *ReverseProxy {
+	if timeouts.Read > 0 {
+		r.serverTimeouts.Read = timeouts.Read
+	}
+	if timeouts.ReadHeader > 0 {
+		r.serverTimeouts.ReadHeader = timeouts.ReadHeader
+	}
+	if timeouts.Write > 0 {
+		r.serverTimeouts.Write = timeouts.Write
+	}
+	if timeouts.Idle > 0 {
+		r.serverTimeouts.Idle = timeouts.Idle
+	}
+	return r
+}
+
+// WithTrustedProxies keeps the forwarding headers of requests received from the
+// trusted proxies, and takes the client IP of their requests from the
+// X-Forwarded-For header. Without trusted proxies the headers of all clients
+// are replaced.
+func (r *ReverseProxy) WithTrustedProxies(trustedProxies *TrustedProxies) *ReverseProxy {
+	r.trustedProxies = trustedProxies
 	return r
 }
 
 func (r *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.trustedProxies.trusted(req.RemoteAddr) {
+		req = req.WithContext(withTrustedProxy(req.Context(), true))
+	}
+	req = middleware.WithClientIP(req, r.trustedProxies.clientIP(req))
 	r.globalFilterFunc(w, req)
 }
 
 func (r *ReverseProxy) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, r)
+	return r.newServer(addr).ListenAndServe()
 }
 
-func (r *ReverseProxy) SetRoute(route *Route) {
-	for _, method := range route.methods {
-		r.router.Handler(method, route.path, newReverseProxyHandler(route))
+// ListenAndServeTLS serves HTTPS on addr with the certificates and protocol
+// settings of tlsConfig.
+func (r *ReverseProxy) ListenAndServeTLS(addr string, tlsConfig *TLSConfig) error {
+	server := r.newServer(addr)
+	server.TLSConfig = tlsConfig.tlsConfig()
+	if !tlsConfig.http2Enabled() {
+		// A non-nil map keeps the server from configuring HTTP/2.
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
+	return server.ListenAndServeTLS("", "")
 }
 
-func newReverseProxyHandler(route *Route) *httputil.ReverseProxy {
-	return &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			dst := route.destination
+func (r *ReverseProxy) newServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           r,
+		ReadTimeout:       r.serverTimeouts.Read,
+		ReadHeaderTimeout: r.serverTimeouts.ReadHeader,
+		WriteTimeout:      r.serverTimeouts.Write,
+		IdleTimeout:       r.serverTimeouts.Idle,
+	}
+}
 
-			req.Host = dst.Host
-			req.URL.Scheme = dst.Scheme
-			req.URL.Host = dst.Host
-			req.URL.Path = dst.Path
+// SetRoute adds the route to the routes of the gateway.
+func (r *ReverseProxy) SetRoute(route *Route) error {
+	r.tableMu.Lock()
+	defer r.tableMu.Unlock()
 
-			req.Header.Set("X-Forwarded-Host", req.Header.Get("Host"))
-		},
+	current := r.currentTable().routes
+	routes := make([]*Route, 0, len(current)+1)
+	routes = append(append(routes, current...), route)
+	return r.swapTable(routes)
+}
+
+// SetRoutes replaces all routes of the gateway. If the routes are invalid, or
+// conflict with each other, the current routes are kept and an error is
+// returned, the routes passed which are not current routes are closed.
+// Requests in flight complete on the routes they were matched on. Routes
+// passed again keep their state, e.g. the health of their targets.
+func (r *ReverseProxy) SetRoutes(routes []*Route) error {
+	r.tableMu.Lock()
+	defer r.tableMu.Unlock()
+
+	return r.swapTable(routes)
+}
+
+// Routes returns the current routes of the gateway.
+func (r *ReverseProxy) Routes() []*Route {
+	return r.currentTable().routes
+}
+
+func (r *ReverseProxy) swapTable(routes []*Route) error {
+	next, err := newRouteTable(routes, r.retryBudget)
+	if err != nil {
+		removeRoutes(routes, r.currentTable().routes)
+		return err
+	}
+
+	// The previous routes are stopped first, as a route replacing one with the
+	// same path reports the same health and circuit breaker metrics.
+	previous := r.currentTable()
+	r.table.Store(next)
+	previous.stop(next)
+	next.start()
+	return nil
+}
+
+func (r *ReverseProxy) currentTable() *routeTable {
+	return r.table.Load().(*routeTable)
+}
+
+func (r *ReverseProxy) serveRoute(w http.ResponseWriter, req *http.Request) {
+	r.currentTable().router.ServeHTTP(w, req)
+}
+
+// routeHandler proxies the requests of a route to one of its targets.
+type routeHandler struct {
+	route        *Route
+	retryBudget  *RetryBudget
+	reverseProxy *httputil.ReverseProxy
+	filterFunc   http.HandlerFunc
+}
+
+func newRouteHandler(route *Route, retryBudget *RetryBudget) *routeHandler {
+	route.newCircuitBreakers()
+
+	h := &routeHandler{
+		route:       route,
+		retryBudget: retryBudget,
+	}
+	h.reverseProxy = &httputil.ReverseProxy{
+		Director:       h.director,
+		Transport:      route.roundTripper(),
+		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.handleError,
+	}
+	for _, filter := range route.filters {
+		if b, ok := filter.(middleware.RouteBinder); ok {
+			b.BindRoute(route.path)
+		}
+	}
+	h.filterFunc = middleware.Chain(route.filters...)(h.serve)
+	return h
+}
+
+func (h *routeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.route.enter()
+	defer h.route.leave()
+
+	middleware.SetRouteTemplate(req, httprouter.ParamsFromContext(req.Context()).MatchedRoutePath())
+	h.filterFunc(w, req)
+}
+
+func (h *routeHandler) serve(w http.ResponseWriter, req *http.Request) {
+	if total := h.route.timeouts.Total; total > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), total)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	h.serveWithRetries(w, req)
+}
+
+func (h *routeHandler) serveWithRetries(w http.ResponseWriter, req *http.Request) {
+	policy := h.route.retryPolicy
+	if policy == nil {
+		h.serveTarget(w, req, nil, &proxyAttempt{})
+		return
+	}
+
+	replayable, err := policy.prepareBody(req)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
 	}
+
+	var tried []*Target
+	for attempt := 1; ; attempt++ {
+		pa := &proxyAttempt{retryable: replayable && attempt < policy.maxAttempts}
+
+		target := h.serveTarget(w, req, tried, pa)
+		if !pa.retry {
+			return
+		}
+		tried = append(tried, target)
+
+		select {
+		case <-req.Context().Done():
+			if isTimeout(req.Context().Err()) {
+				http.Error(w, "upstream request timed out", http.StatusGatewayTimeout)
+			}
+			return
+		case <-time.After(policy.backoff(attempt - 1)):
+		}
+
+		if req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+	}
+}
+
+// serveTarget proxies the request to a target chosen among the healthy
+// targets with a closed circuit, preferring targets that were not tried yet.
+//
+// Only requests reaching the target count towards its circuit breaker and the
+// retry budget, with the status of the upstream response or error. Responses
+// of the gateway itself, e.g. when no target is healthy or the concurrency
+// limit of the target sheds the request, are not failures of the target, nor
+// are requests cancelled by the client.
+func (h *routeHandler) serveTarget(w http.ResponseWriter, req *http.Request, tried []*Target, pa *proxyAttempt) *Target {
+	healthy := excludeTargets(h.route.healthyTargets(), tried)
+	if len(healthy) == 0 {
+		http.Error(w, "no healthy upstream", http.StatusServiceUnavailable)
+		return nil
+	}
+	candidates := availableTargets(healthy)
+	if len(candidates) == 0 {
+		healthy[0].circuitBreaker.failFast(w)
+		return nil
+	}
+	target := h.route.balancer.Next(req, candidates)
+
+	if target.limiter != nil {
+		release, err := target.limiter.Acquire(req.Context())
+		if err != nil {
+			target.limiter.Reject(w, err)
+			return target
+		}
+		defer release()
+	}
+
+	start, completed := time.Now(), false
+	if cb := target.circuitBreaker; cb != nil {
+		done, ok := cb.allow()
+		if !ok {
+			cb.failFast(w)
+			return target
+		}
+		// Deferred, as the reverse proxy panics when streaming the response
+		// fails. Aborted requests have no outcome, as the client and the target
+		// may equally have broken off.
+		defer func() {
+			status := pa.status
+			if !completed {
+				status = 0
+			}
+			done(status, time.Since(start))
+		}()
+	}
+
+	if h.retryBudget != nil && len(tried) == 0 {
+		h.retryBudget.recordRequest()
+	}
+	target.acquire()
+	defer target.release()
+
+	ctx := withProxyAttempt(withTarget(req.Context(), target), pa)
+
+	ctx, span := tracing.StartSpan(ctx, req.Method+" "+h.route.path, tracing.SpanKindClient)
+	span.SetAttribute("http.route", h.route.path)
+	span.SetAttribute("server.address", target.String())
+	defer span.Finish()
+
+	pa.start = start
+	h.reverseProxy.ServeHTTP(w, withConnectionTrace(req.WithContext(ctx), h.route.path, target))
+	completed = true
+	middleware.SetUpstream(req, target.url.Host, pa.latency)
+	return target
+}
+
+func (h *routeHandler) modifyResponse(resp *http.Response) error {
+	span := tracing.SpanFromContext(resp.Request.Context())
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError(resp.Status)
+	}
+
+	pa := proxyAttemptFromContext(resp.Request.Context())
+	if pa != nil {
+		pa.status, pa.latency = resp.StatusCode, time.Since(pa.start)
+	}
+	if pa == nil || !pa.retryable || !h.route.retryPolicy.retryOnStatus[resp.StatusCode] {
+		return nil
+	}
+	if !h.withdrawRetry() {
+		return nil
+	}
+	pa.retry = true
+	return &retryableStatusError{status: resp.StatusCode}
+}
+
+func (h *routeHandler) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	tracing.SpanFromContext(req.Context()).SetError(err.Error())
+
+	// Requests cancelled by the client are no failure of the target.
+	pa := proxyAttemptFromContext(req.Context())
+	if pa != nil && pa.latency == 0 {
+		pa.latency = time.Since(pa.start)
+	}
+	if pa != nil && pa.status == 0 && req.Context().Err() != context.Canceled {
+		pa.status = http.StatusBadGateway
+		if isTimeout(err) {
+			pa.status = http.StatusGatewayTimeout
+		}
+	}
+	if pa != nil && !pa.retry && pa.retryable && req.Context().Err() == nil &&
+		h.route.retryPolicy.retryOnError(err) && h.withdrawRetry() {
+		pa.retry = true
+	}
+	if pa != nil && pa.retry {
+		return
+	}
+
+	logger().Warnf("proxying to %s failed: %v", TargetFromContext(req.Context()), err)
+	if isTimeout(err) {
+		http.Error(w, "upstream request timed out", http.StatusGatewayTimeout)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+func (h *routeHandler) withdrawRetry() bool {
+	if h.retryBudget != nil && !h.retryBudget.withdraw() {
+		upstreamRetries.WithLabelValues(h.route.path, "budget_exhausted").Inc()
+		return false
+	}
+	upstreamRetries.WithLabelValues(h.route.path, "retried").Inc()
+	return true
+}
+
+// excludeTargets returns the candidates that were not tried yet, or all
+// candidates if every candidate was already tried.
+func excludeTargets(candidates, tried []*Target) []*Target {
+	if len(tried) == 0 {
+		return candidates
+	}
+
+	remaining := make([]*Target, 0, len(candidates))
+	for _, c := range candidates {
+		excluded := false
+		for _, t := range tried {
+			if c == t {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			remaining = append(remaining, c)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return candidates
+	}
+	return remaining
+}
+
+func (h *routeHandler) director(req *http.Request) {
+	target := TargetFromContext(req.Context())
+	dst := target.url
+
+	setForwardedHeaders(req, req.Host)
+	if !h.route.preserveHost {
+		req.Host = dst.Host
+	}
+	req.URL.Scheme = dst.Scheme
+	req.URL.Host = dst.Host
+	if target.pathRewrite != nil {
+		ps := httprouter.ParamsFromContext(req.Context())
+		req.URL.Path = target.pathRewrite.Rewrite(req.URL.Path, ps)
+	} else {
+		req.URL.Path = dst.Path
+	}
+	req.URL.RawPath = ""
+
+	setRequestTimeoutHeader(req)
+	tracing.SpanFromContext(req.Context()).Inject(req.Header)
 }
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cdmatta/api-gw/middleware"
+)
+
+// Balancer chooses the target a request is proxied to, among the candidate
+// targets of a route. Candidates are never empty.
+type Balancer interface {
+	Next(req *http.Request, candidates []*Target) *Target
+}
+
+// RoundRobinBalancer cycles through the candidates, ignoring target weights.
+type RoundRobinBalancer struct {
+	counter uint64
+}
+
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{}
+}
+
+func (b *RoundRobinBalancer) Next(_ *http.Request, candidates []*Target) *Target {
+	n := atomic.AddUint64(&b.counter, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// WeightedRoundRobinBalancer implements the smooth weighted round-robin
+// algorithm, which interleaves targets in proportion to their weights.
+type WeightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func NewWeightedRoundRobinBalancer() *WeightedRoundRobinBalancer {
+	return &WeightedRoundRobinBalancer{
+		current: make(map[*Target]int),
+	}
+}
+
+func (b *WeightedRoundRobinBalancer) Next(_ *http.Request, candidates []*Target) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		best  *Target
+		total int
+	)
+	for _, t := range candidates {
+		b.current[t] += t.weight
+		total += t.weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+// LeastOutstandingBalancer chooses the candidate with the fewest requests in
+// flight, relative to its weight.
+type LeastOutstandingBalancer struct{}
+
+func NewLeastOutstandingBalancer() *LeastOutstandingBalancer {
+	return &LeastOutstandingBalancer{}
+}
+
+func (b *LeastOutstandingBalancer) Next(_ *http.Request, candidates []*Target) *Target {
+	best := candidates[0]
+	for _, t := range candidates[1:] {
+		if lessLoaded(t, best) {
+			best = t
+		}
+	}
+	return best
+}
+
+// RandomTwoChoicesBalancer picks two random candidates and chooses the one
+// with fewer requests in flight, relative to its weight.
+type RandomTwoChoicesBalancer struct{}
+
+func NewRandomTwoChoicesBalancer() *RandomTwoChoicesBalancer {
+	return &RandomTwoChoicesBalancer{}
+}
+
+func (b *RandomTwoChoicesBalancer) Next(_ *http.Request, candidates []*Target) *Target {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	if lessLoaded(candidates[j], candidates[i]) {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+func lessLoaded(t1, t2 *Target) bool {
+	return t1.Outstanding()*int64(t2.weight) < t2.Outstanding()*int64(t1.weight)
+}
+
+// HashKeyFunc extracts the key a request is hashed on.
+type HashKeyFunc func(req *http.Request) string
+
+func HeaderHashKey(name string) HashKeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+func CookieHashKey(name string) HashKeyFunc {
+	return func(req *http.Request) string {
+		if c, err := req.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	}
+}
+
+// ClientIPHashKey hashes requests on the client IP, which is taken from the
+// X-Forwarded-For header of requests of trusted proxies.
+func ClientIPHashKey() HashKeyFunc {
+	return func(req *http.Request) string {
+		return middleware.ClientIP(req)
+	}
+}
+
+// ConsistentHashBalancer maps requests with the same hash key onto the same
+// target using weighted rendezvous hashing, so that a change of candidates
+// only remaps the keys of the targets added or removed.
+// Requests without a hash key are balanced in round-robin fashion.
+type ConsistentHashBalancer struct {
+	hashKey  HashKeyFunc
+	fallback Balancer
+}
+
+func NewConsistentHashBalancer(hashKey HashKeyFunc) *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{
+		hashKey:  hashKey,
+		fallback: NewRoundRobinBalancer(),
+	}
+}
+
+func (b *ConsistentHashBalancer) Next(req *http.Request, candidates []*Target) *Target {
+	key := b.hashKey(req)
+	if key == "" {
+		return b.fallback.Next(req, candidates)
+	}
+
+	var (
+		best      *Target
+		bestScore = math.Inf(-1)
+	)
+	for _, t := range candidates {
+		if score := rendezvousScore(key, t); score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+func rendezvousScore(key string, t *Target) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte(t.url.String()))
+
+	// Map the hash into (0, 1) and weigh it, see "Weighted Distributed Hash Tables".
+	x := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	return -float64(t.weight) / math.Log(x)
+}
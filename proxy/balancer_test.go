@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/cdmatta/api-gw/middleware"
+)
+
+func newTestTargets(weights ...int) []*Target {
+	targets := make([]*Target, len(weights))
+	for i, weight := range weights {
+		u := &url.URL{Scheme: "http", Host: "backend-" + string(rune('a'+i)) + ":8080"}
+		targets[i] = NewTarget(u).WithWeight(weight)
+	}
+	return targets
+}
+
+func countSelections(b Balancer, req *http.Request, targets []*Target, n int) map[*Target]int {
+	counts := make(map[*Target]int)
+	for i := 0; i < n; i++ {
+		counts[b.Next(req, targets)]++
+	}
+	return counts
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	targets := newTestTargets(1, 5, 1)
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	counts := countSelections(NewRoundRobinBalancer(), req, targets, 300)
+	for _, target := range targets {
+		if counts[target] != 100 {
+			t.Errorf("invalid selection count, expected: %d, actual: %d, target: '%s'", 100, counts[target], target)
+		}
+	}
+}
+
+func TestWeightedRoundRobinBalancer(t *testing.T) {
+	targets := newTestTargets(5, 1, 1)
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	b := NewWeightedRoundRobinBalancer()
+
+	// Smooth weighted round-robin interleaves the heavier target.
+	expected := []*Target{targets[0], targets[0], targets[1], targets[0], targets[2], targets[0], targets[0]}
+	for i, e := range expected {
+		if actual := b.Next(req, targets); actual != e {
+			t.Errorf("invalid selection at %d, expected: '%s', actual: '%s'", i, e, actual)
+		}
+	}
+}
+
+func TestLeastOutstandingBalancer(t *testing.T) {
+	targets := newTestTargets(1, 1, 2)
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	b := NewLeastOutstandingBalancer()
+
+	targets[0].acquire()
+	targets[2].acquire()
+	targets[2].acquire()
+	targets[1].acquire()
+	targets[1].acquire()
+
+	// 1/1, 2/1 and 2/2 outstanding requests relative to the weight.
+	if actual := b.Next(req, targets); actual != targets[0] {
+		t.Errorf("invalid selection, expected: '%s', actual: '%s'", targets[0], actual)
+	}
+
+	targets[0].acquire()
+	targets[0].acquire()
+	if actual := b.Next(req, targets); actual != targets[2] {
+		t.Errorf("invalid selection, expected: '%s', actual: '%s'", targets[2], actual)
+	}
+}
+
+func TestRandomTwoChoicesBalancer(t *testing.T) {
+	targets := newTestTargets(1, 1)
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	b := NewRandomTwoChoicesBalancer()
+
+	targets[0].acquire()
+
+	// With two candidates both are always compared.
+	counts := countSelections(b, req, targets, 50)
+	if counts[targets[1]] != 50 {
+		t.Errorf("invalid selection count, expected: %d, actual: %d", 50, counts[targets[1]])
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	targets := newTestTargets(1, 1, 1, 1)
+	b := NewConsistentHashBalancer(HeaderHashKey("X-User"))
+
+	selected := make(map[string]*Target)
+	for _, user := range []string{"alice", "bob", "carol", "dave", "eve", "frank"} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", user)
+
+		selected[user] = b.Next(req, targets)
+		for i := 0; i < 10; i++ {
+			if actual := b.Next(req, targets); actual != selected[user] {
+				t.Fatalf("unstable selection, expected: '%s', actual: '%s', key: '%s'", selected[user], actual, user)
+			}
+		}
+	}
+
+	// Removing a target only remaps the keys that were mapped onto it.
+	removed := targets[1]
+	remaining := []*Target{targets[0], targets[2], targets[3]}
+	for user, target := range selected {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", user)
+
+		actual := b.Next(req, remaining)
+		if target != removed && actual != target {
+			t.Errorf("key remapped, expected: '%s', actual: '%s', key: '%s'", target, actual, user)
+		}
+	}
+}
+
+func TestConsistentHashBalancer_ClientIP(t *testing.T) {
+	targets := newTestTargets(1, 1, 1, 1)
+	b := NewConsistentHashBalancer(ClientIPHashKey())
+
+	selected := make(map[*Target]bool)
+	for i := 0; i < 32; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:4711"
+		selected[b.Next(middleware.WithClientIP(req, "198.51.100."+strconv.Itoa(i)), targets)] = true
+	}
+	if len(selected) < 2 {
+		t.Errorf("clients behind the same proxy are mapped onto %d target", len(selected))
+	}
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const circuitBreakerWindowBuckets = 10
+
+var circuitBreakerState = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{Name: "gateway_circuit_breaker_state"},
+	[]string{"route", "target"},
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type windowBucket struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+// CircuitBreaker stops proxying requests to a target that keeps failing. The
+// circuit breaker set on a route holds the settings, each target of the route
+// gets a circuit breaker of its own.
+//
+// The circuit opens after a number of consecutive failures, or when the error
+// rate within a rolling window exceeds a threshold. Responses with a 5xx status
+// and responses slower than the latency threshold count as failures.
+// After the open timeout the circuit turns half-open and lets a limited number
+// of probe requests through: if all of them succeed the circuit closes, any
+// failure opens it again.
+type CircuitBreaker struct {
+	consecutiveFailures int
+	errorRate           float64
+	window              time.Duration
+	minRequests         int
+	latencyThreshold    time.Duration
+	openTimeout         time.Duration
+	halfOpenRequests    int
+	failFastStatus      int
+	failFastBody        string
+
+	now func() time.Time
+
+	mu               sync.Mutex
+	route            string
+	target           string
+	state            CircuitState
+	openedAt         time.Time
+	failureStreak    int
+	buckets          [circuitBreakerWindowBuckets]windowBucket
+	halfOpenInFlight int
+	halfOpenPassed   int
+}
+
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		consecutiveFailures: 5,
+		window:              10 * time.Second,
+		minRequests:         20,
+		openTimeout:         30 * time.Second,
+		halfOpenRequests:    1,
+		failFastStatus:      http.StatusServiceUnavailable,
+		failFastBody:        "circuit breaker is open",
+		now:                 time.Now,
+	}
+}
+
+func (c *CircuitBreaker) WithConsecutiveFailures(failures int) *CircuitBreaker {
+	if failures > 0 {
+		c.consecutiveFailures = failures
+	}
+	return c
+}
+
+// WithErrorRate opens the circuit when the ratio of failed requests within the
+// rolling window exceeds rate, given at least minRequests requests were made.
+func (c *CircuitBreaker) WithErrorRate(rate float64, window time.Duration, minRequests int) *CircuitBreaker {
+	c.errorRate = rate
+	if window > 0 {
+		c.window = window
+	}
+	if minRequests > 0 {
+		c.minRequests = minRequests
+	}
+	return c
+}
+
+func (c *CircuitBreaker) WithLatencyThreshold(threshold time.Duration) *CircuitBreaker {
+	c.latencyThreshold = threshold
+	return c
+}
+
+func (c *CircuitBreaker) WithOpenTimeout(timeout time.Duration) *CircuitBreaker {
+	if timeout > 0 {
+		c.openTimeout = timeout
+	}
+	return c
+}
+
+func (c *CircuitBreaker) WithHalfOpenRequests(requests int) *CircuitBreaker {
+	if requests > 0 {
+		c.halfOpenRequests = requests
+	}
+	return c
+}
+
+func (c *CircuitBreaker) WithFailFastResponse(status int, body string) *CircuitBreaker {
+	if status > 0 {
+		c.failFastStatus = status
+	}
+	if body != "" {
+		c.failFastBody = body
+	}
+	return c
+}
+
+// State returns the current state of the circuit.
+func (c *CircuitBreaker) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.halfOpenIfDue()
+	return c.state
+}
+
+// clone returns a closed circuit breaker with the settings of c.
+func (c *CircuitBreaker) clone() *CircuitBreaker {
+	return &CircuitBreaker{
+		consecutiveFailures: c.consecutiveFailures,
+		errorRate:           c.errorRate,
+		window:              c.window,
+		minRequests:         c.minRequests,
+		latencyThreshold:    c.latencyThreshold,
+		openTimeout:         c.openTimeout,
+		halfOpenRequests:    c.halfOpenRequests,
+		failFastStatus:      c.failFastStatus,
+		failFastBody:        c.failFastBody,
+		now:                 c.now,
+	}
+}
+
+// bind labels the state metric and the logs of the circuit breaker with its
+// route and target, and publishes the current state.
+func (c *CircuitBreaker) bind(route, target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.halfOpenIfDue()
+	c.route, c.target = route, target
+	circuitBreakerState.WithLabelValues(route, target).Set(float64(c.state))
+}
+
+// unbind removes the state metric of the circuit breaker.
+func (c *CircuitBreaker) unbind() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.route != "" {
+		circuitBreakerState.DeleteLabelValues(c.route, c.target)
+	}
+}
+
+// available reports whether the circuit lets requests through, without
+// admitting one.
+func (c *CircuitBreaker) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.halfOpenIfDue()
+	switch c.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return c.halfOpenInFlight+c.halfOpenPassed < c.halfOpenRequests
+	}
+	return true
+}
+
+// allow reports whether a request may be proxied. If so, the caller must
+// report the outcome of the request through done, with status 0 if the request
+// has no outcome for the target, e.g. it was cancelled by the client or
+// aborted while streaming the response.
+func (c *CircuitBreaker) allow() (done func(status int, latency time.Duration), ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.halfOpenIfDue()
+
+	switch c.state {
+	case CircuitOpen:
+		return nil, false
+	case CircuitHalfOpen:
+		if c.halfOpenInFlight+c.halfOpenPassed >= c.halfOpenRequests {
+			return nil, false
+		}
+		c.halfOpenInFlight++
+		return func(status int, latency time.Duration) {
+			c.record(true, status, latency)
+		}, true
+	}
+
+	return func(status int, latency time.Duration) {
+		c.record(false, status, latency)
+	}, true
+}
+
+func (c *CircuitBreaker) failFast(w http.ResponseWriter) {
+	http.Error(w, c.failFastBody, c.failFastStatus)
+}
+
+func (c *CircuitBreaker) isFailure(status int, latency time.Duration) bool {
+	if status >= http.StatusInternalServerError {
+		return true
+	}
+	return c.latencyThreshold > 0 && latency > c.latencyThreshold
+}
+
+// record records the outcome of a request, releasing the slot of a probe
+// request even if the request has no outcome.
+func (c *CircuitBreaker) record(probe bool, status int, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failure := c.isFailure(status, latency)
+	if probe {
+		c.halfOpenInFlight--
+		if c.state != CircuitHalfOpen || status == 0 {
+			return
+		}
+		if failure {
+			c.transition(CircuitOpen)
+			return
+		}
+		c.halfOpenPassed++
+		if c.halfOpenPassed >= c.halfOpenRequests {
+			c.transition(CircuitClosed)
+		}
+		return
+	}
+
+	if c.state != CircuitClosed || status == 0 {
+		return
+	}
+
+	bucket := c.currentBucket()
+	bucket.total++
+	if failure {
+		bucket.failures++
+		c.failureStreak++
+	} else {
+		c.failureStreak = 0
+	}
+
+	if c.failureStreak >= c.consecutiveFailures {
+		c.transition(CircuitOpen)
+		return
+	}
+
+	if c.errorRate > 0 {
+		total, failures := c.windowCounts()
+		if total >= c.minRequests && float64(failures)/float64(total) >= c.errorRate {
+			c.transition(CircuitOpen)
+		}
+	}
+}
+
+func (c *CircuitBreaker) halfOpenIfDue() {
+	if c.state == CircuitOpen && c.now().Sub(c.openedAt) >= c.openTimeout {
+		c.transition(CircuitHalfOpen)
+	}
+}
+
+func (c *CircuitBreaker) transition(state CircuitState) {
+	from := c.state
+	c.state = state
+
+	switch state {
+	case CircuitOpen:
+		c.openedAt = c.now()
+	case CircuitHalfOpen:
+		c.halfOpenInFlight = 0
+		c.halfOpenPassed = 0
+	case CircuitClosed:
+		c.failureStreak = 0
+		c.buckets = [circuitBreakerWindowBuckets]windowBucket{}
+	}
+
+	if c.route != "" {
+		circuitBreakerState.WithLabelValues(c.route, c.target).Set(float64(state))
+		logger().Warnf("circuit breaker of target %s of route %s changed from %s to %s", c.target, c.route, from, state)
+	}
+}
+
+func (c *CircuitBreaker) bucketWidth() time.Duration {
+	return c.window / circuitBreakerWindowBuckets
+}
+
+func (c *CircuitBreaker) currentBucket() *windowBucket {
+	now := c.now()
+	width := c.bucketWidth()
+	start := now.Truncate(width)
+
+	bucket := &c.buckets[(now.UnixNano()/int64(width))%circuitBreakerWindowBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = windowBucket{start: start}
+	}
+	return bucket
+}
+
+func (c *CircuitBreaker) windowCounts() (total, failures int) {
+	oldest := c.now().Add(-c.window)
+	for i := range c.buckets {
+		if c.buckets[i].start.After(oldest) {
+			total += c.buckets[i].total
+			failures += c.buckets[i].failures
+		}
+	}
+	return total, failures
+}
@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func newTestCircuitBreaker(clock *fakeClock) *CircuitBreaker {
+	cb := NewCircuitBreaker()
+	cb.now = clock.Now
+	return cb
+}
+
+func callCircuitBreaker(cb *CircuitBreaker, status int, latency time.Duration) bool {
+	done, ok := cb.allow()
+	if ok {
+		done(status, latency)
+	}
+	return ok
+}
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cb := newTestCircuitBreaker(clock).
+		WithConsecutiveFailures(3).
+		WithOpenTimeout(time.Second).
+		WithHalfOpenRequests(2)
+
+	callCircuitBreaker(cb, http.StatusBadGateway, 0)
+	callCircuitBreaker(cb, http.StatusBadGateway, 0)
+	callCircuitBreaker(cb, http.StatusOK, 0)
+	callCircuitBreaker(cb, http.StatusBadGateway, 0)
+	callCircuitBreaker(cb, http.StatusBadGateway, 0)
+	if cb.State() != CircuitClosed {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitClosed, cb.State())
+	}
+
+	callCircuitBreaker(cb, http.StatusServiceUnavailable, 0)
+	if cb.State() != CircuitOpen {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitOpen, cb.State())
+	}
+	if callCircuitBreaker(cb, http.StatusOK, 0) {
+		t.Fatal("open circuit allowed a request")
+	}
+
+	clock.Advance(time.Second)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitHalfOpen, cb.State())
+	}
+
+	done1, ok1 := cb.allow()
+	done2, ok2 := cb.allow()
+	_, ok3 := cb.allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Fatalf("half-open circuit allowed wrong number of probes: %t %t %t", ok1, ok2, ok3)
+	}
+	done1(http.StatusOK, 0)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitHalfOpen, cb.State())
+	}
+	done2(http.StatusOK, 0)
+	if cb.State() != CircuitClosed {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitClosed, cb.State())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenFailure(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cb := newTestCircuitBreaker(clock).
+		WithConsecutiveFailures(1).
+		WithOpenTimeout(time.Second)
+
+	callCircuitBreaker(cb, http.StatusInternalServerError, 0)
+	clock.Advance(time.Second)
+	callCircuitBreaker(cb, http.StatusInternalServerError, 0)
+
+	if cb.State() != CircuitOpen {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitOpen, cb.State())
+	}
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cb := newTestCircuitBreaker(clock).
+		WithConsecutiveFailures(100).
+		WithErrorRate(0.5, 10*time.Second, 10)
+
+	for i := 0; i < 9; i++ {
+		status := http.StatusOK
+		if i%2 == 0 {
+			status = http.StatusInternalServerError
+		}
+		callCircuitBreaker(cb, status, 0)
+		clock.Advance(time.Second)
+	}
+	if cb.State() != CircuitClosed {
+		t.Fatalf("invalid state below minimum requests, expected: %s, actual: %s", CircuitClosed, cb.State())
+	}
+
+	// The failures of the first seconds drop out of the window.
+	clock.Advance(5 * time.Second)
+	callCircuitBreaker(cb, http.StatusOK, 0)
+	if cb.State() != CircuitClosed {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitClosed, cb.State())
+	}
+
+	for i := 0; i < 10; i++ {
+		callCircuitBreaker(cb, http.StatusInternalServerError, 0)
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitOpen, cb.State())
+	}
+}
+
+func TestCircuitBreaker_LatencyThreshold(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cb := newTestCircuitBreaker(clock).
+		WithConsecutiveFailures(2).
+		WithLatencyThreshold(100 * time.Millisecond)
+
+	callCircuitBreaker(cb, http.StatusOK, 50*time.Millisecond)
+	callCircuitBreaker(cb, http.StatusOK, 150*time.Millisecond)
+	callCircuitBreaker(cb, http.StatusOK, 200*time.Millisecond)
+
+	if cb.State() != CircuitOpen {
+		t.Fatalf("invalid state, expected: %s, actual: %s", CircuitOpen, cb.State())
+	}
+}
+
+func TestRouteHandler_CircuitBreakerPerTarget(t *testing.T) {
+	failing := newCountingBackend(http.StatusInternalServerError)
+	defer failing.Close()
+	healthy := newCountingBackend(http.StatusOK)
+	defer healthy.Close()
+
+	failingTarget, healthyTarget := failing.target(), healthy.target()
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(failingTarget, healthyTarget).
+		WithCircuitBreaker(NewCircuitBreaker().WithConsecutiveFailures(2))
+	handler := newRouteHandler(route, nil)
+
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	}
+
+	if state := failingTarget.CircuitBreaker().State(); state != CircuitOpen {
+		t.Errorf("invalid state of failing target, expected: %s, actual: %s", CircuitOpen, state)
+	}
+	if state := healthyTarget.CircuitBreaker().State(); state != CircuitClosed {
+		t.Errorf("invalid state of healthy target, expected: %s, actual: %s", CircuitClosed, state)
+	}
+	if hits := failing.Hits(); hits != 2 {
+		t.Errorf("invalid hits of failing target, expected: 2, actual: %d", hits)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestCircuitBreaker_BindPublishesState(t *testing.T) {
+	cb := NewCircuitBreaker().WithConsecutiveFailures(1)
+	callCircuitBreaker(cb, http.StatusInternalServerError, 0)
+
+	cb.bind("/bind", "http://backend")
+	defer cb.unbind()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "gateway_circuit_breaker_state" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "route" && label.GetValue() == "/bind" {
+					if actual := CircuitState(metric.GetGauge().GetValue()); actual != CircuitOpen {
+						t.Errorf("invalid published state, expected: %s, actual: %s", CircuitOpen, actual)
+					}
+					return
+				}
+			}
+		}
+	}
+	t.Error("state of bound circuit breaker not published")
+}
+
+func TestRouteHandler_AbortedProbeReleased(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("abort") == "" {
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer backend.Close()
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	backendUrl, _ := url.Parse(backend.URL)
+	target := NewTarget(backendUrl)
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(target).
+		WithCircuitBreaker(newTestCircuitBreaker(clock).WithConsecutiveFailures(1).WithOpenTimeout(time.Second))
+	gateway := httptest.NewServer(newRouteHandler(route, nil))
+	defer gateway.Close()
+
+	callCircuitBreaker(target.CircuitBreaker(), http.StatusInternalServerError, 0)
+	clock.Advance(time.Second)
+
+	if resp, err := http.Get(gateway.URL + "/test?abort=1"); err == nil {
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if !target.CircuitBreaker().available() {
+		t.Fatal("aborted probe was not released")
+	}
+
+	resp, err := http.Get(gateway.URL + "/test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if state := target.CircuitBreaker().State(); state != CircuitClosed {
+		t.Errorf("invalid state, expected: %s, actual: %s", CircuitClosed, state)
+	}
+}
+
+func TestRouteHandler_ClientCancellationNotCountedAsFailure(t *testing.T) {
+	inFlight := make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	target := NewTarget(backendUrl)
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(target).
+		WithCircuitBreaker(NewCircuitBreaker().WithConsecutiveFailures(1))
+	handler := newRouteHandler(route, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(ctx))
+		close(done)
+	}()
+	<-inFlight
+	cancel()
+	<-done
+
+	if state := target.CircuitBreaker().State(); state != CircuitClosed {
+		t.Errorf("client cancellation opened the circuit, state: %s", state)
+	}
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderForwarded       = "Forwarded"
+	HeaderXForwardedFor   = "X-Forwarded-For"
+	HeaderXForwardedProto = "X-Forwarded-Proto"
+	HeaderXForwardedHost  = "X-Forwarded-Host"
+	HeaderXForwardedPort  = "X-Forwarded-Port"
+)
+
+// TrustedProxies are the networks of the proxies in front of the gateway. The
+// forwarding headers of requests from a trusted proxy are appended to, the
+// forwarding headers of any other client are replaced.
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// NewTrustedProxies parses the networks in CIDR notation, single addresses
+// are accepted as well.
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	t := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s'", cidr)
+			}
+			t.networks = append(t.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s': %v", cidr, err)
+		}
+		t.networks = append(t.networks, network)
+	}
+	return t, nil
+}
+
+func (t *TrustedProxies) trusted(remoteAddr string) bool {
+	if t == nil {
+		return false
+	}
+	ip := net.ParseIP(remoteHost(remoteAddr))
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client of the request. For requests of a
+// trusted proxy, it is the last address of X-Forwarded-For which is not a
+// trusted proxy itself, otherwise the remote address of the request.
+func (t *TrustedProxies) clientIP(req *http.Request) string {
+	ip := remoteHost(req.RemoteAddr)
+	if !t.trusted(ip) {
+		return ip
+	}
+
+	var forwardedFor []string
+	for _, value := range req.Header.Values(HeaderXForwardedFor) {
+		forwardedFor = append(forwardedFor, strings.Split(value, ",")...)
+	}
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !t.trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+type trustedProxyKey struct{}
+
+func withTrustedProxy(ctx context.Context, trusted bool) context.Context {
+	return context.WithValue(ctx, trustedProxyKey{}, trusted)
+}
+
+// fromTrustedProxy reports whether the request was received from a trusted
+// proxy.
+func fromTrustedProxy(ctx context.Context) bool {
+	trusted, _ := ctx.Value(trustedProxyKey{}).(bool)
+	return trusted
+}
+
+// setForwardedHeaders sets the X-Forwarded-* and Forwarded headers of the
+// upstream request. The client address is appended to X-Forwarded-For by
+// httputil.ReverseProxy, so untrusted values are only removed here.
+func setForwardedHeaders(req *http.Request, host string) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	port := localPort(req, proto)
+
+	forwarded := forwardedElement(remoteHost(req.RemoteAddr), host, proto)
+	if fromTrustedProxy(req.Context()) {
+		setIfAbsent(req.Header, HeaderXForwardedProto, proto)
+		setIfAbsent(req.Header, HeaderXForwardedHost, host)
+		setIfAbsent(req.Header, HeaderXForwardedPort, port)
+		if prior := req.Header.Values(HeaderForwarded); len(prior) > 0 {
+			forwarded = strings.Join(prior, ", ") + ", " + forwarded
+		}
+	} else {
+		req.Header.Del(HeaderXForwardedFor)
+		req.Header.Set(HeaderXForwardedProto, proto)
+		req.Header.Set(HeaderXForwardedHost, host)
+		req.Header.Set(HeaderXForwardedPort, port)
+	}
+	req.Header.Set(HeaderForwarded, forwarded)
+}
+
+// forwardedElement returns the RFC 7239 forwarded-element of the hop.
+func forwardedElement(clientIP, host, proto string) string {
+	node := clientIP
+	if strings.Contains(node, ":") {
+		node = "[" + node + "]"
+	}
+	return "for=" + quoteForwarded(node) + ";host=" + quoteForwarded(host) + ";proto=" + proto
+}
+
+// quoteForwarded quotes values which are not a token, e.g. IPv6 addresses and
+// hosts with a port.
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]\"") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
+
+func setIfAbsent(header http.Header, key, value string) {
+	if header.Get(key) == "" {
+		header.Set(key, value)
+	}
+}
+
+// localPort returns the port the request was received on.
+func localPort(req *http.Request, proto string) string {
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return port
+		}
+	}
+	if _, port, err := net.SplitHostPort(req.Host); err == nil {
+		return port
+	}
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
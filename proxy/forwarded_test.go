@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestReverseProxy_ForwardedHeaders(t *testing.T) {
+	var received http.Header
+	var receivedHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, receivedHost = r.Header, r.Host
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	trustedProxies, err := NewTrustedProxies("10.0.0.0/8", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		header       http.Header
+		preserveHost bool
+		expected     http.Header
+		expectedHost string
+	}{
+		{
+			name:       "untrusted client",
+			remoteAddr: "203.0.113.7:4711",
+			header: http.Header{
+				HeaderXForwardedFor:   {"198.51.100.1"},
+				HeaderXForwardedProto: {"https"},
+				HeaderForwarded:       {"for=198.51.100.1"},
+			},
+			expected: http.Header{
+				HeaderXForwardedFor:   {"203.0.113.7"},
+				HeaderXForwardedProto: {"http"},
+				HeaderXForwardedHost:  {"api.example.com"},
+				HeaderXForwardedPort:  {"80"},
+				HeaderForwarded:       {"for=203.0.113.7;host=api.example.com;proto=http"},
+			},
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:4711",
+			header: http.Header{
+				HeaderXForwardedFor:   {"198.51.100.1"},
+				HeaderXForwardedProto: {"https"},
+				HeaderXForwardedPort:  {"443"},
+				HeaderForwarded:       {"for=198.51.100.1;proto=https"},
+			},
+			expected: http.Header{
+				HeaderXForwardedFor:   {"198.51.100.1, 10.1.2.3"},
+				HeaderXForwardedProto: {"https"},
+				HeaderXForwardedHost:  {"api.example.com"},
+				HeaderXForwardedPort:  {"443"},
+				HeaderForwarded:       {"for=198.51.100.1;proto=https, for=10.1.2.3;host=api.example.com;proto=http"},
+			},
+		},
+		{
+			name:         "preserve host",
+			remoteAddr:   "[2001:db8::1]:4711",
+			preserveHost: true,
+			expected: http.Header{
+				HeaderXForwardedFor: {"2001:db8::1"},
+				HeaderForwarded:     {`for="[2001:db8::1]";host=api.example.com;proto=http`},
+			},
+			expectedHost: "api.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := NewRoute().
+				WithMethods([]string{http.MethodGet}).
+				WithPath("/test").
+				WithTargets(NewTarget(backendUrl)).
+				WithPreserveHost(tt.preserveHost)
+
+			gateway := NewReverseProxy().WithTrustedProxies(trustedProxies)
+			if err := gateway.SetRoute(route); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://api.example.com/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			gateway.ServeHTTP(httptest.NewRecorder(), req)
+
+			for key := range tt.expected {
+				if actual, expected := received.Get(key), tt.expected.Get(key); actual != expected {
+					t.Errorf("invalid %s, expected: '%s', actual: '%s'", key, expected, actual)
+				}
+			}
+			expectedHost := tt.expectedHost
+			if expectedHost == "" {
+				expectedHost = backendUrl.Host
+			}
+			if receivedHost != expectedHost {
+				t.Errorf("invalid host, expected: '%s', actual: '%s'", expectedHost, receivedHost)
+			}
+		})
+	}
+}
+
+func TestNewTrustedProxies(t *testing.T) {
+	trustedProxies, err := NewTrustedProxies("10.0.0.0/8", "2001:db8::/32", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		trusted    bool
+	}{
+		{"10.255.0.1:1234", true},
+		{"11.0.0.1:1234", false},
+		{"[2001:db8::1]:1234", true},
+		{"192.0.2.1:1234", true},
+		{"192.0.2.2:1234", false},
+		{"invalid", false},
+	}
+	for _, tt := range tests {
+		if trusted := trustedProxies.trusted(tt.remoteAddr); trusted != tt.trusted {
+			t.Errorf("invalid trust of %s, expected: %v, actual: %v", tt.remoteAddr, tt.trusted, trusted)
+		}
+	}
+
+	if _, err := NewTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("expected error for invalid network, none occurred")
+	}
+	if _, err := NewTrustedProxies("not-an-ip"); err == nil {
+		t.Error("expected error for invalid address, none occurred")
+	}
+}
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	trustedProxies, err := NewTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"untrusted client", "203.0.113.7:4711", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:4711", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:4711", []string{"192.0.2.9, 198.51.100.1", "10.9.9.9"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.1.2.3:4711", nil, "10.1.2.3"},
+		{"invalid hop", "10.1.2.3:4711", []string{"198.51.100.1, unknown"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header[HeaderXForwardedFor] = tt.forwardedFor
+
+			if actual := trustedProxies.clientIP(req); actual != tt.expected {
+				t.Errorf("invalid client IP, expected: '%s', actual: '%s'", tt.expected, actual)
+			}
+		})
+	}
+}
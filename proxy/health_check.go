@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const maxHealthCheckBodySize = 64 * 1024
+
+var upstreamHealthy = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{Name: "gateway_upstream_healthy"},
+	[]string{"route", "target"},
+)
+
+// HealthCheck describes how the targets of a route are actively probed.
+// A target is marked unhealthy after unhealthyThreshold consecutive failed
+// probes and healthy again after healthyThreshold consecutive passed probes.
+type HealthCheck struct {
+	path               string
+	interval           time.Duration
+	timeout            time.Duration
+	statusMin          int
+	statusMax          int
+	body               string
+	healthyThreshold   int
+	unhealthyThreshold int
+}
+
+func NewHealthCheck(path string) *HealthCheck {
+	return &HealthCheck{
+		path:               path,
+		interval:           10 * time.Second,
+		timeout:            2 * time.Second,
+		statusMin:          200,
+		statusMax:          399,
+		healthyThreshold:   2,
+		unhealthyThreshold: 3,
+	}
+}
+
+func (h *HealthCheck) WithInterval(interval time.Duration) *HealthCheck {
+	if interval > 0 {
+		h.interval = interval
+	}
+	return h
+}
+
+func (h *HealthCheck) WithTimeout(timeout time.Duration) *HealthCheck {
+	if timeout > 0 {
+		h.timeout = timeout
+	}
+	return h
+}
+
+func (h *HealthCheck) WithExpectedStatus(min, max int) *HealthCheck {
+	if min > 0 {
+		h.statusMin = min
+	}
+	if max > 0 {
+		h.statusMax = max
+	}
+	return h
+}
+
+func (h *HealthCheck) WithExpectedBody(body string) *HealthCheck {
+	h.body = body
+	return h
+}
+
+func (h *HealthCheck) WithThresholds(healthy, unhealthy int) *HealthCheck {
+	if healthy > 0 {
+		h.healthyThreshold = healthy
+	}
+	if unhealthy > 0 {
+		h.unhealthyThreshold = unhealthy
+	}
+	return h
+}
+
+// healthChecker probes the targets of a single route until stopped.
+type healthChecker struct {
+	route   string
+	check   *HealthCheck
+	client  *http.Client
+	targets []*Target
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newHealthChecker(route string, check *HealthCheck, transport http.RoundTripper) *healthChecker {
+	return &healthChecker{
+		route:  route,
+		check:  check,
+		client: &http.Client{Timeout: check.timeout, Transport: transport},
+	}
+}
+
+func (c *healthChecker) start(targets []*Target) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.targets = targets
+
+	for _, target := range targets {
+		upstreamHealthy.WithLabelValues(c.route, target.String()).Set(healthValue(target))
+
+		c.wg.Add(1)
+		go func(target *Target) {
+			defer c.wg.Done()
+			c.run(ctx, target)
+		}(target)
+	}
+}
+
+// stop stops probing and removes the health metrics of the targets, which are
+// no longer routed to.
+func (c *healthChecker) stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+
+	for _, target := range c.targets {
+		upstreamHealthy.DeleteLabelValues(c.route, target.String())
+	}
+}
+
+// run probes the target right away, and then once per interval.
+func (c *healthChecker) run(ctx context.Context, target *Target) {
+	ticker := time.NewTicker(c.check.interval)
+	defer ticker.Stop()
+
+	var passed, failed int
+	for {
+		err := c.probe(ctx, target)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			passed, failed = passed+1, 0
+		} else {
+			passed, failed = 0, failed+1
+		}
+
+		switch {
+		case passed >= c.check.healthyThreshold && !target.Healthy():
+			target.setHealthy(true)
+			upstreamHealthy.WithLabelValues(c.route, target.String()).Set(1)
+			logger().Infof("target %s of route %s is healthy", target, c.route)
+		case failed >= c.check.unhealthyThreshold && target.Healthy():
+			target.setHealthy(false)
+			upstreamHealthy.WithLabelValues(c.route, target.String()).Set(0)
+			logger().Warnf("target %s of route %s is unhealthy: %v", target, c.route, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func healthValue(target *Target) float64 {
+	if target.Healthy() {
+		return 1
+	}
+	return 0
+}
+
+func (c *healthChecker) probe(ctx context.Context, target *Target) error {
+	probeUrl := *target.url
+	probeUrl.Path = c.check.path
+	probeUrl.RawPath = ""
+	probeUrl.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBodySize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < c.check.statusMin || resp.StatusCode > c.check.statusMax {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if c.check.body != "" && !strings.Contains(string(body), c.check.body) {
+		return fmt.Errorf("response body does not contain '%s'", c.check.body)
+	}
+	return nil
+}
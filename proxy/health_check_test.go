@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func waitFor(t *testing.T, condition func() bool, description string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	var failing int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.Write([]byte("DOWN"))
+			return
+		}
+		w.Write([]byte("UP"))
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	target := NewTarget(backendUrl)
+
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(target).
+		WithHealthCheck(NewHealthCheck("/health").
+			WithInterval(5*time.Millisecond).
+			WithExpectedBody("UP").
+			WithThresholds(2, 2))
+
+	route.startHealthChecks()
+	defer route.stopHealthChecks()
+
+	if !target.Healthy() || len(route.healthyTargets()) != 1 {
+		t.Fatal("target should initially be healthy")
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	waitFor(t, func() bool { return !target.Healthy() }, "target to become unhealthy")
+
+	if len(route.healthyTargets()) != 0 {
+		t.Error("unhealthy target should be removed from routing")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	waitFor(t, func() bool { return target.Healthy() }, "target to recover")
+}
+
+func TestHealthChecker_UnexpectedStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	target := NewTarget(backendUrl)
+
+	checker := newHealthChecker("/test", NewHealthCheck("/health").
+		WithInterval(5*time.Millisecond).
+		WithThresholds(1, 1), http.DefaultTransport)
+	checker.start([]*Target{target})
+	defer checker.stop()
+
+	waitFor(t, func() bool { return !target.Healthy() }, "target to become unhealthy")
+}
+
+func TestHealthChecker_FirstProbeAndMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	target := NewTarget(backendUrl)
+
+	checker := newHealthChecker("/first-probe", NewHealthCheck("/health").
+		WithInterval(time.Hour).
+		WithThresholds(1, 1), http.DefaultTransport)
+	checker.start([]*Target{target})
+
+	waitFor(t, func() bool { return !target.Healthy() }, "first probe to mark the target unhealthy")
+	if series := healthSeries(t, "/first-probe"); series != 1 {
+		t.Errorf("invalid number of health series, expected: 1, actual: %d", series)
+	}
+
+	checker.stop()
+	if series := healthSeries(t, "/first-probe"); series != 0 {
+		t.Errorf("health series of stopped checker not removed, actual: %d", series)
+	}
+}
+
+func healthSeries(t *testing.T, route string) int {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	count := 0
+	for _, family := range families {
+		if family.GetName() != "gateway_upstream_healthy" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "route" && label.GetValue() == route {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+func TestHealthChecker_MetricsRedactPassword(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	backendUrl.User = url.UserPassword("gateway", "s3cret")
+	checker := newHealthChecker("/redacted", NewHealthCheck("/health").WithInterval(time.Hour), http.DefaultTransport)
+	checker.start([]*Target{NewTarget(backendUrl)})
+	defer checker.stop()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if strings.Contains(label.GetValue(), "s3cret") {
+					t.Errorf("password in label %s of %s: %s", label.GetName(), family.GetName(), label.GetValue())
+				}
+			}
+		}
+	}
+	if series := healthSeries(t, "/redacted"); series != 1 {
+		t.Errorf("invalid number of health series, expected: 1, actual: %d", series)
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cdmatta/api-gw/httprouter"
+)
+
+var (
+	ErrPatternUnterminatedPlaceholder     = "unterminated placeholder in backend path template '%s'"
+	ErrPatternEmptyPlaceholder            = "empty placeholder in backend path template '%s'"
+	ErrPatternUnknownPlaceholder          = "placeholder '%s' in backend path template '%s' is not a parameter of route '%s'"
+	ErrPatternPlaceholderKindMismatch     = "placeholder '%s' in backend path template '%s' does not match the parameter kind in route '%s'"
+	ErrPatternPrefixWithTemplatedPath     = "strip/add prefix cannot be combined with the templated backend path '%s'"
+	ErrPatternPrefixWithBackendPath       = "strip/add prefix cannot be combined with the backend path '%s'"
+	ErrPatternStripPrefixNotInRoute       = "strip prefix '%s' is not a prefix of route '%s'"
+	ErrPatternAddPrefixWithoutLeadingPath = "add prefix '%s' must begin with '/'"
+)
+
+type pathSegment struct {
+	literal  string
+	param    string
+	catchAll bool
+}
+
+// PathRewrite computes the path of the upstream request from the path of the
+// incoming request and the path parameters captured by the router.
+//
+// The backend path is either a plain path, which replaces the request path, or
+// a template referring to route parameters, e.g. `/v2/accounts/{id}/{*rest}`
+// for the route `/accounts/:id/*rest`. Alternatively a prefix can be stripped
+// from and/or added to the request path.
+type PathRewrite struct {
+	segments    []pathSegment
+	templated   bool
+	stripPrefix string
+	addPrefix   string
+}
+
+// NewPathRewrite validates the backend path template, or the strip/add prefix
+// options, against the route path and returns the resulting PathRewrite.
+func NewPathRewrite(routePath, backendPath, stripPrefix, addPrefix string) (*PathRewrite, error) {
+	segments, err := parsePathTemplate(backendPath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PathRewrite{
+		segments:    segments,
+		stripPrefix: stripPrefix,
+		addPrefix:   addPrefix,
+	}
+
+	routeParams := routePathParams(routePath)
+	for _, s := range segments {
+		if s.param == "" {
+			continue
+		}
+		p.templated = true
+
+		catchAll, ok := routeParams[s.param]
+		if !ok {
+			return nil, fmt.Errorf(ErrPatternUnknownPlaceholder, s.param, backendPath, routePath)
+		}
+		if catchAll != s.catchAll {
+			return nil, fmt.Errorf(ErrPatternPlaceholderKindMismatch, s.param, backendPath, routePath)
+		}
+	}
+
+	if stripPrefix == "" && addPrefix == "" {
+		return p, nil
+	}
+
+	if p.templated {
+		return nil, fmt.Errorf(ErrPatternPrefixWithTemplatedPath, backendPath)
+	}
+	if backendPath != "" && backendPath != "/" {
+		return nil, fmt.Errorf(ErrPatternPrefixWithBackendPath, backendPath)
+	}
+	if !strings.HasPrefix(routePath, stripPrefix) {
+		return nil, fmt.Errorf(ErrPatternStripPrefixNotInRoute, stripPrefix, routePath)
+	}
+	if addPrefix != "" && addPrefix[0] != '/' {
+		return nil, fmt.Errorf(ErrPatternAddPrefixWithoutLeadingPath, addPrefix)
+	}
+
+	return p, nil
+}
+
+// Rewrite returns the (unescaped) upstream path for the given request path.
+func (p *PathRewrite) Rewrite(path string, ps httprouter.Params) string {
+	if p.stripPrefix != "" || p.addPrefix != "" {
+		path = p.addPrefix + strings.TrimPrefix(path, p.stripPrefix)
+		if path == "" || path[0] != '/' {
+			path = "/" + path
+		}
+		return path
+	}
+
+	if !p.templated {
+		if len(p.segments) == 0 {
+			return ""
+		}
+		return p.segments[0].literal
+	}
+
+	var sb strings.Builder
+	for _, s := range p.segments {
+		switch {
+		case s.param == "":
+			sb.WriteString(s.literal)
+		case s.catchAll:
+			// The catch-all value includes the leading '/', which the template
+			// already provides before the placeholder.
+			sb.WriteString(strings.TrimPrefix(ps.ByName(s.param), "/"))
+		default:
+			sb.WriteString(ps.ByName(s.param))
+		}
+	}
+	return sb.String()
+}
+
+func parsePathTemplate(template string) ([]pathSegment, error) {
+	var (
+		segments []pathSegment
+		rest     = template
+	)
+
+	for len(rest) > 0 {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			segments = append(segments, pathSegment{literal: rest})
+			break
+		}
+		if open > 0 {
+			segments = append(segments, pathSegment{literal: rest[:open]})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf(ErrPatternUnterminatedPlaceholder, template)
+		}
+
+		name := rest[open+1 : open+end]
+		catchAll := strings.HasPrefix(name, "*")
+		name = strings.TrimPrefix(name, "*")
+		if name == "" {
+			return nil, fmt.Errorf(ErrPatternEmptyPlaceholder, template)
+		}
+
+		segments = append(segments, pathSegment{param: name, catchAll: catchAll})
+		rest = rest[open+end+1:]
+	}
+
+	return segments, nil
+}
+
+// routePathParams returns the parameter names of the route path, mapped to
+// whether the parameter is a catch-all parameter.
+func routePathParams(routePath string) map[string]bool {
+	params := make(map[string]bool)
+	for _, segment := range strings.Split(routePath, "/") {
+		i := strings.IndexAny(segment, ":*")
+		if i < 0 || i == len(segment)-1 {
+			continue
+		}
+		params[segment[i+1:]] = segment[i] == '*'
+	}
+	return params
+}
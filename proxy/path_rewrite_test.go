@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/cdmatta/api-gw/httprouter"
+)
+
+func TestPathRewrite_Rewrite(t *testing.T) {
+	fixture := []struct {
+		routePath   string
+		backendPath string
+		stripPrefix string
+		addPrefix   string
+		requestPath string
+		params      httprouter.Params
+		expected    string
+	}{
+		{"/hw", "/hw", "", "", "/hw", nil, "/hw"},
+		{"/hw", "", "", "", "/hw", nil, ""},
+		{"/users/:id", "/v2/accounts/{id}", "", "", "/users/42", httprouter.Params{{Key: "id", Value: "42"}}, "/v2/accounts/42"},
+		{"/users/:id/*rest", "/v2/accounts/{id}/{*rest}", "", "", "/users/42/a/b",
+			httprouter.Params{{Key: "id", Value: "42"}, {Key: "rest", Value: "/a/b"}}, "/v2/accounts/42/a/b"},
+		{"/users/:id/*rest", "/v2/accounts/{id}/{*rest}", "", "", "/users/42/",
+			httprouter.Params{{Key: "id", Value: "42"}, {Key: "rest", Value: "/"}}, "/v2/accounts/42/"},
+		{"/user_:name", "/people/{name}", "", "", "/user_gopher", httprouter.Params{{Key: "name", Value: "gopher"}}, "/people/gopher"},
+		{"/api/*rest", "", "/api", "", "/api/users/42", nil, "/users/42"},
+		{"/api/*rest", "/", "/api", "/v2", "/api/users/42", nil, "/v2/users/42"},
+		{"/api", "", "/api", "", "/api", nil, "/"},
+		{"/users", "", "", "/v2", "/users", nil, "/v2/users"},
+	}
+
+	for _, f := range fixture {
+		rewrite, err := NewPathRewrite(f.routePath, f.backendPath, f.stripPrefix, f.addPrefix)
+		if err != nil {
+			t.Errorf("unexpected error: %v, route: '%s', backend path: '%s'", err, f.routePath, f.backendPath)
+			continue
+		}
+
+		if actual := rewrite.Rewrite(f.requestPath, f.params); actual != f.expected {
+			t.Errorf("invalid result, expected: '%s', actual: '%s', route: '%s', backend path: '%s'",
+				f.expected, actual, f.routePath, f.backendPath)
+		}
+	}
+}
+
+func TestPathRewrite_InvalidConfig(t *testing.T) {
+	fixture := []struct {
+		routePath   string
+		backendPath string
+		stripPrefix string
+		addPrefix   string
+	}{
+		{"/users/:id", "/v2/accounts/{id", "", ""},
+		{"/users/:id", "/v2/accounts/{}", "", ""},
+		{"/users/:id", "/v2/accounts/{name}", "", ""},
+		{"/users/:id", "/v2/accounts/{*id}", "", ""},
+		{"/users/*rest", "/v2/accounts/{rest}", "", ""},
+		{"/users/:id", "/v2/accounts/{id}", "/users", ""},
+		{"/users/:id", "/v2", "/users", ""},
+		{"/users/:id", "", "/api", ""},
+		{"/users/:id", "", "", "v2"},
+	}
+
+	for _, f := range fixture {
+		if _, err := NewPathRewrite(f.routePath, f.backendPath, f.stripPrefix, f.addPrefix); err == nil {
+			t.Errorf("expected error, none occurred, route: '%s', backend path: '%s', strip prefix: '%s', add prefix: '%s'",
+				f.routePath, f.backendPath, f.stripPrefix, f.addPrefix)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	RetryOnConnectError = "connect"
+	RetryOnReset        = "reset"
+	RetryOnTimeout      = "timeout"
+)
+
+const retryBudgetWindowBuckets = 10
+
+var upstreamRetries = promauto.NewCounterVec(
+	prometheus.CounterOpts{Name: "gateway_upstream_retries_total"},
+	[]string{"route", "result"},
+)
+
+// RetryPolicy describes when and how often a failed upstream request is
+// retried. Only requests with an idempotent method, or whose body could be
+// buffered, are retried.
+type RetryPolicy struct {
+	maxAttempts     int
+	retryOnStatus   map[int]bool
+	retryOnErrors   map[string]bool
+	baseBackoff     time.Duration
+	maxBackoff      time.Duration
+	bufferBodyLimit int64
+}
+
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		maxAttempts: maxAttempts,
+		retryOnStatus: map[int]bool{
+			http.StatusBadGateway:         true,
+			http.StatusServiceUnavailable: true,
+			http.StatusGatewayTimeout:     true,
+		},
+		retryOnErrors: map[string]bool{
+			RetryOnConnectError: true,
+			RetryOnReset:        true,
+		},
+		baseBackoff: 25 * time.Millisecond,
+		maxBackoff:  250 * time.Millisecond,
+	}
+}
+
+func (p *RetryPolicy) WithRetryOnStatus(statuses ...int) *RetryPolicy {
+	if len(statuses) > 0 {
+		p.retryOnStatus = make(map[int]bool)
+		for _, status := range statuses {
+			p.retryOnStatus[status] = true
+		}
+	}
+	return p
+}
+
+func (p *RetryPolicy) WithRetryOnErrors(kinds ...string) (*RetryPolicy, error) {
+	if len(kinds) > 0 {
+		p.retryOnErrors = make(map[string]bool)
+		for _, kind := range kinds {
+			switch kind {
+			case RetryOnConnectError, RetryOnReset, RetryOnTimeout:
+				p.retryOnErrors[kind] = true
+			default:
+				return nil, fmt.Errorf("unknown retry error kind '%s'", kind)
+			}
+		}
+	}
+	return p, nil
+}
+
+// WithBackoff sets the exponential backoff between attempts. The actual delay
+// is chosen at random up to the exponential delay ("full jitter").
+func (p *RetryPolicy) WithBackoff(base, max time.Duration) *RetryPolicy {
+	if base > 0 {
+		p.baseBackoff = base
+	}
+	if max > 0 {
+		p.maxBackoff = max
+	}
+	return p
+}
+
+// WithBufferBodyLimit allows retrying requests of any method, by buffering
+// request bodies of up to limit bytes.
+func (p *RetryPolicy) WithBufferBodyLimit(limit int64) *RetryPolicy {
+	p.bufferBodyLimit = limit
+	return p
+}
+
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.baseBackoff << uint(retry)
+	if d <= 0 || d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func (p *RetryPolicy) retryOnError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return p.retryOnErrors[RetryOnTimeout]
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return p.retryOnErrors[RetryOnConnectError]
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return p.retryOnErrors[RetryOnReset]
+	}
+	return false
+}
+
+// prepareBody makes the request body replayable, returning false if the
+// request cannot be retried.
+func (p *RetryPolicy) prepareBody(req *http.Request) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return isIdempotent(req.Method) || p.bufferBodyLimit > 0, nil
+	}
+	if p.bufferBodyLimit <= 0 || req.ContentLength > p.bufferBodyLimit {
+		return false, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, p.bufferBodyLimit+1))
+	if err != nil {
+		return false, err
+	}
+
+	if int64(len(body)) > p.bufferBodyLimit {
+		// Too large to buffer, stream what was read followed by the rest.
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return false, nil
+	}
+
+	req.Body.Close()
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// RetryBudget caps the retries across the gateway to a percentage of the
+// requests within a rolling window of ten seconds, so that retries cannot
+// multiply the load on backends that are already failing. A minimum number
+// of retries per second is always allowed, for low traffic.
+type RetryBudget struct {
+	percent          float64
+	minRetriesPerSec int
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets [retryBudgetWindowBuckets]retryBudgetBucket
+}
+
+type retryBudgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func NewRetryBudget(percent float64, minRetriesPerSec int) *RetryBudget {
+	return &RetryBudget{
+		percent:          percent,
+		minRetriesPerSec: minRetriesPerSec,
+		now:              time.Now,
+	}
+}
+
+func (b *RetryBudget) recordRequest() {
+	b.mu.Lock()
+	b.bucket().requests++
+	b.mu.Unlock()
+}
+
+// withdraw reports whether a retry is within the budget, and if so accounts
+// for it.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := b.bucket()
+
+	var requests, retries int
+	for i := range b.buckets {
+		if current.second-b.buckets[i].second < retryBudgetWindowBuckets {
+			requests += b.buckets[i].requests
+			retries += b.buckets[i].retries
+		}
+	}
+
+	allowed := float64(requests)*b.percent/100 + float64(b.minRetriesPerSec*retryBudgetWindowBuckets)
+	if float64(retries) >= allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+func (b *RetryBudget) bucket() *retryBudgetBucket {
+	second := b.now().Unix()
+	bucket := &b.buckets[second%retryBudgetWindowBuckets]
+	if bucket.second != second {
+		*bucket = retryBudgetBucket{second: second}
+	}
+	return bucket
+}
+
+// retryableStatusError rejects an upstream response so that it is retried.
+type retryableStatusError struct {
+	status int
+}
+
+func (e *retryableStatusError) Error() string {
+	return fmt.Sprintf("upstream responded with retryable status %d", e.status)
+}
+
+// proxyAttempt carries the retry decision and the upstream status of a single
+// upstream attempt between the route handler and the reverse proxy callbacks.
+type proxyAttempt struct {
+	retryable bool
+	retry     bool
+	// status is the status of the upstream response, the status the gateway
+	// responds with if the upstream request failed, or 0 if the client
+	// cancelled the request before either.
+	status int
+	// latency is the time from start until the upstream response headers or
+	// error arrived.
+	start   time.Time
+	latency time.Duration
+}
+
+type proxyAttemptKey struct{}
+
+func withProxyAttempt(ctx context.Context, attempt *proxyAttempt) context.Context {
+	return context.WithValue(ctx, proxyAttemptKey{}, attempt)
+}
+
+func proxyAttemptFromContext(ctx context.Context) *proxyAttempt {
+	a, _ := ctx.Value(proxyAttemptKey{}).(*proxyAttempt)
+	return a
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingBackend struct {
+	*httptest.Server
+	status int32
+	hits   int32
+	body   atomic.Value
+}
+
+func newCountingBackend(status int) *countingBackend {
+	b := &countingBackend{status: int32(status)}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&b.hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		b.body.Store(string(body))
+		w.WriteHeader(int(atomic.LoadInt32(&b.status)))
+	}))
+	return b
+}
+
+func (b *countingBackend) target() *Target {
+	u, _ := url.Parse(b.URL)
+	return NewTarget(u)
+}
+
+func (b *countingBackend) Hits() int {
+	return int(atomic.LoadInt32(&b.hits))
+}
+
+func newTestRetryPolicy(maxAttempts int) *RetryPolicy {
+	return NewRetryPolicy(maxAttempts).WithBackoff(time.Millisecond, time.Millisecond)
+}
+
+func TestRouteHandler_RetryOnOtherTarget(t *testing.T) {
+	failing := newCountingBackend(http.StatusServiceUnavailable)
+	defer failing.Close()
+	healthy := newCountingBackend(http.StatusOK)
+	defer healthy.Close()
+
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(failing.target(), healthy.target()).
+		WithRetryPolicy(newTestRetryPolicy(2))
+	handler := newRouteHandler(route, nil)
+
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("invalid status, expected: %d, actual: %d", http.StatusOK, w.Code)
+		}
+	}
+
+	// Every request that hit the failing target was retried on the other one.
+	if failing.Hits() == 0 || healthy.Hits() != 4 {
+		t.Errorf("invalid backend hits, failing: %d, healthy: %d", failing.Hits(), healthy.Hits())
+	}
+}
+
+func TestRouteHandler_RetryExhausted(t *testing.T) {
+	failing := newCountingBackend(http.StatusBadGateway)
+	defer failing.Close()
+
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(failing.target()).
+		WithRetryPolicy(newTestRetryPolicy(3))
+	handler := newRouteHandler(route, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusBadGateway, w.Code)
+	}
+	if failing.Hits() != 3 {
+		t.Errorf("invalid backend hits, expected: %d, actual: %d", 3, failing.Hits())
+	}
+}
+
+func TestRouteHandler_RetryNonIdempotent(t *testing.T) {
+	failing := newCountingBackend(http.StatusServiceUnavailable)
+	defer failing.Close()
+
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(failing.target()).
+		WithRetryPolicy(newTestRetryPolicy(3))
+	handler := newRouteHandler(route, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload")))
+	if failing.Hits() != 1 {
+		t.Errorf("unbuffered POST retried, backend hits: %d", failing.Hits())
+	}
+
+	route.WithRetryPolicy(newTestRetryPolicy(3).WithBufferBodyLimit(1024))
+	handler = newRouteHandler(route, nil)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("payload")))
+	if failing.Hits() != 4 {
+		t.Errorf("buffered POST not retried, backend hits: %d", failing.Hits())
+	}
+	if body := failing.body.Load(); body != "payload" {
+		t.Errorf("invalid replayed body, expected: '%s', actual: '%s'", "payload", body)
+	}
+}
+
+func TestRouteHandler_RetryConnectError(t *testing.T) {
+	closed := newCountingBackend(http.StatusOK)
+	closedTarget := closed.target()
+	closed.Close()
+
+	healthy := newCountingBackend(http.StatusOK)
+	defer healthy.Close()
+
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(closedTarget, healthy.target()).
+		WithRetryPolicy(newTestRetryPolicy(2))
+	handler := newRouteHandler(route, nil)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("invalid status, expected: %d, actual: %d", http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	budget := NewRetryBudget(10, 0)
+	budget.now = clock.Now
+
+	for i := 0; i < 100; i++ {
+		budget.recordRequest()
+	}
+	for i := 0; i < 10; i++ {
+		if !budget.withdraw() {
+			t.Fatalf("retry %d denied within budget", i)
+		}
+	}
+	if budget.withdraw() {
+		t.Fatal("retry allowed beyond budget")
+	}
+
+	// Requests and retries drop out of the window after ten seconds.
+	clock.Advance(10 * time.Second)
+	if budget.withdraw() {
+		t.Fatal("retry allowed without requests in window")
+	}
+
+	budget = NewRetryBudget(10, 1)
+	budget.now = clock.Now
+	if !budget.withdraw() {
+		t.Fatal("minimum retries denied")
+	}
+}
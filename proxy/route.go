@@ -1,17 +1,42 @@
 package proxy
 
 import (
-	"net/url"
+	"net/http"
+	"sync"
+
+	"github.com/cdmatta/api-gw/middleware"
 )
 
 type Route struct {
-	methods     []string
-	path        string
-	destination *url.URL
+	methods       []string
+	path          string
+	targets       []*Target
+	balancer      Balancer
+	healthCheck   *HealthCheck
+	healthChecker *healthChecker
+
+	circuitBreaker *CircuitBreaker
+	retryPolicy    *RetryPolicy
+	timeouts       Timeouts
+	upstreamTLS    *UpstreamTLS
+	connectionPool ConnectionPool
+	preserveHost   bool
+	filters        []middleware.Middleware
+
+	// The requests in flight on the route, so that the filters and the
+	// connections of a removed route are closed once its last request
+	// completed.
+	mu        sync.Mutex
+	transport http.RoundTripper
+	inFlight  int
+	removed   bool
+	closed    bool
 }
 
 func NewRoute() *Route {
-	return &Route{}
+	return &Route{
+		balancer: NewRoundRobinBalancer(),
+	}
 }
 
 func (r *Route) WithMethods(methods []string) *Route {
@@ -24,7 +49,200 @@ func (r *Route) WithPath(path string) *Route {
 	return r
 }
 
-func (r *Route) WithDestination(destination *url.URL) *Route {
-	r.destination = destination
+func (r *Route) WithTargets(targets ...*Target) *Route {
+	r.targets = append(r.targets, targets...)
+	return r
+}
+
+func (r *Route) WithBalancer(balancer Balancer) *Route {
+	r.balancer = balancer
+	return r
+}
+
+func (r *Route) Methods() []string {
+	return r.methods
+}
+
+func (r *Route) Path() string {
+	return r.path
+}
+
+func (r *Route) Targets() []*Target {
+	return r.targets
+}
+
+func (r *Route) Filters() []middleware.Middleware {
+	return r.filters
+}
+
+func (r *Route) WithHealthCheck(healthCheck *HealthCheck) *Route {
+	r.healthCheck = healthCheck
+	return r
+}
+
+// WithCircuitBreaker gives each target of the route a circuit breaker with the
+// settings of circuitBreaker.
+func (r *Route) WithCircuitBreaker(circuitBreaker *CircuitBreaker) *Route {
+	r.circuitBreaker = circuitBreaker
+	return r
+}
+
+func (r *Route) WithRetryPolicy(retryPolicy *RetryPolicy) *Route {
+	r.retryPolicy = retryPolicy
+	return r
+}
+
+func (r *Route) WithTimeouts(timeouts Timeouts) *Route {
+	r.timeouts = timeouts
 	return r
 }
+
+func (r *Route) WithConnectionPool(pool ConnectionPool) *Route {
+	r.connectionPool = pool
+	return r
+}
+
+// WithPreserveHost sends the Host header of the client to the targets instead
+// of the host of the target.
+func (r *Route) WithPreserveHost(preserveHost bool) *Route {
+	r.preserveHost = preserveHost
+	return r
+}
+
+// WithFilters sets the middlewares applied to the requests of the route, in
+// the given order, after the global middlewares.
+func (r *Route) WithFilters(filters ...middleware.Middleware) *Route {
+	r.filters = append(r.filters, filters...)
+	return r
+}
+
+func (r *Route) WithUpstreamTLS(upstreamTLS *UpstreamTLS) *Route {
+	r.upstreamTLS = upstreamTLS
+	return r
+}
+
+// availableTargets returns the targets whose circuit lets requests through.
+func availableTargets(targets []*Target) []*Target {
+	available := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if t.circuitBreaker == nil || t.circuitBreaker.available() {
+			available = append(available, t)
+		}
+	}
+	return available
+}
+
+// newCircuitBreakers gives the targets their circuit breakers, keeping those
+// of targets which already have one.
+func (r *Route) newCircuitBreakers() {
+	if r.circuitBreaker == nil {
+		return
+	}
+	for _, t := range r.targets {
+		if t.circuitBreaker == nil {
+			t.circuitBreaker = r.circuitBreaker.clone()
+		}
+	}
+}
+
+func (r *Route) bindCircuitBreakers() {
+	for _, t := range r.targets {
+		if t.circuitBreaker != nil {
+			t.circuitBreaker.bind(r.path, t.String())
+		}
+	}
+}
+
+func (r *Route) unbindCircuitBreakers() {
+	for _, t := range r.targets {
+		if t.circuitBreaker != nil {
+			t.circuitBreaker.unbind()
+		}
+	}
+}
+
+// healthyTargets returns the targets currently passing their health checks.
+func (r *Route) healthyTargets() []*Target {
+	if r.healthCheck == nil {
+		return r.targets
+	}
+
+	healthy := make([]*Target, 0, len(r.targets))
+	for _, t := range r.targets {
+		if t.Healthy() {
+			healthy = append(healthy, t)
+		}
+	}
+	return healthy
+}
+
+func (r *Route) startHealthChecks() {
+	if r.healthCheck == nil || r.healthChecker != nil {
+		return
+	}
+	r.healthChecker = newHealthChecker(r.path, r.healthCheck, r.roundTripper())
+	r.healthChecker.start(r.targets)
+}
+
+func (r *Route) stopHealthChecks() {
+	if r.healthChecker == nil {
+		return
+	}
+	r.healthChecker.stop()
+	r.healthChecker = nil
+}
+
+func (r *Route) enter() {
+	r.mu.Lock()
+	r.inFlight++
+	r.mu.Unlock()
+}
+
+func (r *Route) leave() {
+	r.mu.Lock()
+	r.inFlight--
+	idle := r.removed && r.inFlight == 0 && !r.closed
+	r.closed = r.closed || idle
+	r.mu.Unlock()
+
+	if idle {
+		r.close()
+	}
+}
+
+// remove closes the filters and the idle connections of the route once the
+// requests in flight on the route completed.
+func (r *Route) remove() {
+	r.mu.Lock()
+	r.removed = true
+	idle := r.inFlight == 0 && !r.closed
+	r.closed = r.closed || idle
+	r.mu.Unlock()
+
+	if idle {
+		r.close()
+	}
+}
+
+// roundTripper returns the transport of the route, which is built once so that
+// the route keeps its connections when the routes of the gateway change.
+func (r *Route) roundTripper() http.RoundTripper {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.transport == nil {
+		r.transport = newTransport(r)
+	}
+	return r.transport
+}
+
+func (r *Route) close() {
+	middleware.Close(r.filters...)
+
+	r.mu.Lock()
+	transport := r.transport
+	r.mu.Unlock()
+	if t, ok := transport.(*http.Transport); ok && transport != http.DefaultTransport {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/cdmatta/api-gw/httprouter"
+)
+
+// routeTable is an immutable set of routes and the router dispatching to them.
+// Changing the routes of the gateway builds a new table which is swapped in
+// atomically, so that in-flight requests complete on the previous table.
+type routeTable struct {
+	router *httprouter.Router
+	routes []*Route
+}
+
+// newRouteTable builds the router for the routes, turning the panics of the
+// router on invalid or conflicting paths into an error.
+func newRouteTable(routes []*Route, retryBudget *RetryBudget) (table *routeTable, err error) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			table, err = nil, fmt.Errorf("invalid routes: %v", rcv)
+		}
+	}()
+
+	router := &httprouter.Router{SaveMatchedRoutePath: true}
+	for _, route := range routes {
+		if len(route.targets) == 0 {
+			return nil, fmt.Errorf("route '%s' has no targets", route.path)
+		}
+
+		handler := newRouteHandler(route, retryBudget)
+		for _, method := range route.methods {
+			router.Handler(method, route.path, handler)
+		}
+	}
+
+	return &routeTable{
+		router: router,
+		routes: routes,
+	}, nil
+}
+
+// start binds the circuit breakers and starts the health checks of the routes,
+// keeping the state of routes carried over from the previous table.
+func (t *routeTable) start() {
+	for _, route := range t.routes {
+		route.bindCircuitBreakers()
+		route.startHealthChecks()
+	}
+}
+
+// stop stops the health checks and removes the circuit breaker metrics of the
+// routes that are not part of the next table. Their filters are closed once
+// their requests in flight completed.
+func (t *routeTable) stop(next *routeTable) {
+	removeRoutes(t.routes, next.routes)
+}
+
+// removeRoutes tears down the routes that are not retained, the same way for
+// routes removed from the gateway and for routes of a table that failed to
+// build.
+func removeRoutes(routes, retained []*Route) {
+	isRetained := make(map[*Route]bool, len(retained))
+	for _, route := range retained {
+		isRetained[route] = true
+	}
+
+	for _, route := range routes {
+		if !isRetained[route] {
+			route.stopHealthChecks()
+			route.unbindCircuitBreakers()
+			route.remove()
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cdmatta/api-gw/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestRoute(path string, backend *countingBackend) *Route {
+	return NewRoute().
+		WithMethods([]string{http.MethodGet}).
+		WithPath(path).
+		WithTargets(backend.target())
+}
+
+func serveGateway(gateway *ReverseProxy, path string) int {
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func TestReverseProxy_SetRoutes(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{newTestRoute("/users/:id", backend)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if code := serveGateway(gateway, "/users/42"); code != http.StatusOK {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusOK, code)
+	}
+
+	if err := gateway.SetRoutes([]*Route{newTestRoute("/orders", backend)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if code := serveGateway(gateway, "/users/42"); code != http.StatusNotFound {
+		t.Errorf("invalid status of removed route, expected: %d, actual: %d", http.StatusNotFound, code)
+	}
+	if code := serveGateway(gateway, "/orders"); code != http.StatusOK {
+		t.Errorf("invalid status of added route, expected: %d, actual: %d", http.StatusOK, code)
+	}
+}
+
+func TestReverseProxy_SetRoutesConflict(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{newTestRoute("/users/:id", backend)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conflicting := []*Route{
+		newTestRoute("/orders", backend),
+		newTestRoute("/orders", backend),
+	}
+	if err := gateway.SetRoutes(conflicting); err == nil {
+		t.Fatal("expected error for conflicting routes, none occurred")
+	}
+
+	if err := gateway.SetRoute(newTestRoute("/users/:name/profile", backend)); err == nil {
+		t.Fatal("expected error for conflicting wildcard, none occurred")
+	}
+
+	if len(gateway.Routes()) != 1 {
+		t.Errorf("invalid number of routes, expected: %d, actual: %d", 1, len(gateway.Routes()))
+	}
+	if code := serveGateway(gateway, "/users/42"); code != http.StatusOK {
+		t.Errorf("previous routes not kept, status: %d", code)
+	}
+	if code := serveGateway(gateway, "/orders"); code != http.StatusNotFound {
+		t.Errorf("rejected route served, status: %d", code)
+	}
+}
+
+func TestReverseProxy_RouteTemplateLabel(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	gateway := NewReverseProxy().
+		WithGlobalFilterFunc(middleware.Compose(middleware.NewAccessLoggingMetricsMiddleware()))
+	if err := gateway.SetRoutes([]*Route{newTestRoute("/users/:id", backend)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	serveGateway(gateway, "/users/42")
+	serveGateway(gateway, "/unknown/42")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	uris := map[string]bool{}
+	for _, family := range families {
+		if family.GetName() != "gateway_requests_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "uri" {
+					uris[label.GetValue()] = true
+				}
+			}
+		}
+	}
+
+	for _, uri := range []string{"/users/:id", middleware.RouteTemplateNotFound} {
+		if !uris[uri] {
+			t.Errorf("missing uri label '%s', labels: %v", uri, uris)
+		}
+	}
+	for _, uri := range []string{"/users/42", "/unknown/42"} {
+		if uris[uri] {
+			t.Errorf("raw uri '%s' used as label", uri)
+		}
+	}
+}
+
+func TestReverseProxy_RouteFilters(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	headers := middleware.NewHeadersMiddleware().
+		WithResponseHeaders(middleware.HeaderRules{Set: map[string]string{"X-Route": "users"}})
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{
+		newTestRoute("/users/:id", backend).WithFilters(headers),
+		newTestRoute("/orders", backend),
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/users/42", "users"},
+		{"/orders", ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if actual := w.Header().Get("X-Route"); actual != tt.expected {
+			t.Errorf("%s: invalid X-Route header, expected: '%s', actual: '%s'", tt.path, tt.expected, actual)
+		}
+	}
+}
+
+type closingFilter struct {
+	closed bool
+}
+
+func (f *closingFilter) Name() string {
+	return "closing"
+}
+
+func (f *closingFilter) Priority() int {
+	return middleware.PriorityCustomMiddleware
+}
+
+func (f *closingFilter) FilterFunction(next http.HandlerFunc) http.HandlerFunc {
+	return next
+}
+
+func (f *closingFilter) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestReverseProxy_SetRoutesClosesFilters(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	removed, retained := &closingFilter{}, &closingFilter{}
+	users := newTestRoute("/users/:id", backend).WithFilters(retained)
+
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{users, newTestRoute("/orders", backend).WithFilters(removed)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := gateway.SetRoutes([]*Route{users}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !removed.closed {
+		t.Error("filter of removed route not closed")
+	}
+	if retained.closed {
+		t.Error("filter of retained route closed")
+	}
+}
+
+func TestReverseProxy_SetRoutesClosesNewRoutesOnError(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	current, built := &closingFilter{}, &closingFilter{}
+	users := newTestRoute("/users/:id", backend).WithFilters(current)
+
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{users}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conflicting := []*Route{
+		users,
+		newTestRoute("/orders", backend).WithFilters(built),
+		newTestRoute("/orders", backend),
+	}
+	if err := gateway.SetRoutes(conflicting); err == nil {
+		t.Fatal("expected error for conflicting routes, none occurred")
+	}
+
+	if !built.closed {
+		t.Error("filter of route built for the rejected routes not closed")
+	}
+	if current.closed {
+		t.Error("filter of current route closed")
+	}
+	if code := serveGateway(gateway, "/users/42"); code != http.StatusOK {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusOK, code)
+	}
+}
+
+func TestReverseProxy_SetRoutesClosesFiltersAfterRequests(t *testing.T) {
+	inFlight, release := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	filter := &closingFilter{}
+	route := NewRoute().
+		WithMethods([]string{http.MethodGet}).
+		WithPath("/orders").
+		WithTargets(NewTarget(backendUrl)).
+		WithFilters(filter)
+
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{route}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		serveGateway(gateway, "/orders")
+		close(done)
+	}()
+	<-inFlight
+
+	if err := gateway.SetRoutes(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	route.mu.Lock()
+	closed := filter.closed
+	route.mu.Unlock()
+	if closed {
+		t.Error("filter closed while a request was in flight")
+	}
+
+	close(release)
+	<-done
+	if !filter.closed {
+		t.Error("filter of removed route not closed after its last request")
+	}
+}
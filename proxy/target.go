@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/cdmatta/api-gw/middleware"
+)
+
+// Target is a single upstream instance of a route's backend.
+type Target struct {
+	url         *url.URL
+	weight      int
+	pathRewrite *PathRewrite
+	limiter     *middleware.ConcurrencyLimiter
+
+	circuitBreaker *CircuitBreaker
+
+	// The number of requests currently proxied to the target.
+	outstanding int64
+
+	// Set to 1 while the target fails its active health checks.
+	unhealthy int32
+}
+
+func NewTarget(url *url.URL) *Target {
+	return &Target{
+		url:    url,
+		weight: 1,
+	}
+}
+
+func (t *Target) WithWeight(weight int) *Target {
+	if weight > 0 {
+		t.weight = weight
+	}
+	return t
+}
+
+func (t *Target) WithPathRewrite(pathRewrite *PathRewrite) *Target {
+	t.pathRewrite = pathRewrite
+	return t
+}
+
+// WithConcurrencyLimiter limits the requests in flight to the target.
+func (t *Target) WithConcurrencyLimiter(limiter *middleware.ConcurrencyLimiter) *Target {
+	t.limiter = limiter
+	return t
+}
+
+// CircuitBreaker returns the circuit breaker of the target, or nil if its
+// route has none.
+func (t *Target) CircuitBreaker() *CircuitBreaker {
+	return t.circuitBreaker
+}
+
+func (t *Target) URL() *url.URL {
+	return t.url
+}
+
+func (t *Target) Weight() int {
+	return t.weight
+}
+
+// Outstanding returns the number of requests currently proxied to the target.
+func (t *Target) Outstanding() int64 {
+	return atomic.LoadInt64(&t.outstanding)
+}
+
+// Healthy reports whether the target passes its active health checks.
+// Targets of routes without health checks are always healthy.
+func (t *Target) Healthy() bool {
+	return atomic.LoadInt32(&t.unhealthy) == 0
+}
+
+func (t *Target) setHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&t.unhealthy, 0)
+	} else {
+		atomic.StoreInt32(&t.unhealthy, 1)
+	}
+}
+
+// String returns the URL of the target with the password redacted, as it is
+// used in metric labels, logs and spans.
+func (t *Target) String() string {
+	return t.url.Redacted()
+}
+
+func (t *Target) acquire() {
+	atomic.AddInt64(&t.outstanding, 1)
+}
+
+func (t *Target) release() {
+	atomic.AddInt64(&t.outstanding, -1)
+}
+
+type targetKey struct{}
+
+func withTarget(ctx context.Context, target *Target) context.Context {
+	return context.WithValue(ctx, targetKey{}, target)
+}
+
+// TargetFromContext returns the target chosen for the proxied request, or nil
+// if no target has been chosen yet.
+func TargetFromContext(ctx context.Context) *Target {
+	t, _ := ctx.Value(targetKey{}).(*Target)
+	return t
+}
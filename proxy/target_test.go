@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cdmatta/api-gw/middleware"
+)
+
+func TestRouteHandler_TargetConcurrencyLimit(t *testing.T) {
+	inFlight, release := make(chan struct{}, 1), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- struct{}{}
+		<-release
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	limiter := middleware.NewConcurrencyLimiter(middleware.ConcurrencyLimit{
+		MaxInFlight:  1,
+		MaxQueue:     1,
+		QueueTimeout: 20 * time.Millisecond,
+		RetryAfter:   2 * time.Second,
+	})
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(NewTarget(backendUrl).WithConcurrencyLimiter(limiter))
+	handler := newRouteHandler(route, nil)
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+		done <- w.Code
+	}()
+	<-inFlight
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if actual := w.Header().Get("Retry-After"); actual != "2" {
+		t.Errorf("invalid Retry-After, expected: 2, actual: %s", actual)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("invalid status of admitted request, expected: %d, actual: %d", http.StatusOK, code)
+	}
+	if limiter.InFlight() != 0 {
+		t.Errorf("expected no requests in flight, actual: %d", limiter.InFlight())
+	}
+}
+
+func TestRouteHandler_ShedRequestsNotCountedAsFailures(t *testing.T) {
+	inFlight, release := make(chan struct{}, 1), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- struct{}{}
+		<-release
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	target := NewTarget(backendUrl).WithConcurrencyLimiter(middleware.NewConcurrencyLimiter(middleware.ConcurrencyLimit{MaxInFlight: 1}))
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(target).
+		WithCircuitBreaker(NewCircuitBreaker().WithConsecutiveFailures(1))
+	retryBudget := NewRetryBudget(20, 0)
+	retryBudget.now = func() time.Time { return time.Unix(0, 0) }
+	handler := newRouteHandler(route, retryBudget)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+		close(done)
+	}()
+	<-inFlight
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("invalid status, expected: %d, actual: %d", http.StatusServiceUnavailable, w.Code)
+		}
+	}
+	close(release)
+	<-done
+
+	if state := target.CircuitBreaker().State(); state != CircuitClosed {
+		t.Errorf("shed requests opened the circuit, state: %s", state)
+	}
+	if requests := retryBudget.bucket().requests; requests != 1 {
+		t.Errorf("invalid requests of retry budget, expected: 1, actual: %d", requests)
+	}
+}
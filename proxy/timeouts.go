@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequestTimeoutHeader carries the time, in milliseconds, left until the
+// deadline of the proxied request, so that backends can give up in time.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// ServerTimeouts bound the reading and writing of the requests accepted by
+// the gateway. Zero values disable a timeout.
+type ServerTimeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// Timeouts bound the upstream requests of a route. Zero values disable a
+// timeout. The total timeout includes all retry attempts.
+type Timeouts struct {
+	Connect        time.Duration
+	ResponseHeader time.Duration
+	Total          time.Duration
+}
+
+// setRequestTimeoutHeader replaces the timeout header of the client, which
+// the gateway does not enforce, with the time left until the deadline of the
+// request, if any.
+func setRequestTimeoutHeader(req *http.Request) {
+	req.Header.Del(RequestTimeoutHeader)
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return
+	}
+
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+	req.Header.Set(RequestTimeoutHeader, strconv.FormatInt(remaining, 10))
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteHandler_TotalTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	backendUrl, _ := url.Parse(backend.URL)
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(NewTarget(backendUrl)).
+		WithTimeouts(Timeouts{Total: 50 * time.Millisecond})
+	handler := newRouteHandler(route, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusGatewayTimeout, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "timed out") {
+		t.Errorf("invalid body: '%s'", w.Body.String())
+	}
+}
+
+func TestRouteHandler_ResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	backendUrl, _ := url.Parse(backend.URL)
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(NewTarget(backendUrl)).
+		WithTimeouts(Timeouts{ResponseHeader: 50 * time.Millisecond})
+	handler := newRouteHandler(route, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("invalid status, expected: %d, actual: %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
+
+func TestRouteHandler_RequestTimeoutHeader(t *testing.T) {
+	var header string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(RequestTimeoutHeader)
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(NewTarget(backendUrl)).
+		WithTimeouts(Timeouts{Total: 5 * time.Second})
+	handler := newRouteHandler(route, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	remaining, err := strconv.Atoi(header)
+	if err != nil || remaining <= 0 || remaining > 5000 {
+		t.Errorf("invalid %s header: '%s'", RequestTimeoutHeader, header)
+	}
+}
+
+func TestRouteHandler_RequestTimeoutHeaderOfClient(t *testing.T) {
+	header := "unset"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(RequestTimeoutHeader)
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	route := NewRoute().
+		WithPath("/test").
+		WithTargets(NewTarget(backendUrl))
+	handler := newRouteHandler(route, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set(RequestTimeoutHeader, "600000")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if header != "" {
+		t.Errorf("%s header of client passed to backend: '%s'", RequestTimeoutHeader, header)
+	}
+}
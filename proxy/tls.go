@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoCertificates = errors.New("no certificates configured")
+
+	ErrPatternUnknownTLSVersion  = "unknown TLS version '%s'"
+	ErrPatternUnknownCipherSuite = "unknown cipher suite '%s'"
+)
+
+// CertificateFiles are the PEM encoded certificate chain and private key of a
+// certificate, and optionally a DER encoded OCSP response to staple.
+type CertificateFiles struct {
+	CertFile       string
+	KeyFile        string
+	OCSPStapleFile string
+}
+
+// CertificateStore holds the certificates of a TLS listener and selects the
+// certificate matching the server name of the client (SNI). The certificates
+// can be reloaded from disk while serving.
+type CertificateStore struct {
+	files []CertificateFiles
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	byName   map[string]*tls.Certificate
+	modTimes []time.Time
+}
+
+// NewCertificateStore loads the certificates. The first certificate is served
+// to clients not sending a server name, or one no certificate matches.
+func NewCertificateStore(files ...CertificateFiles) (*CertificateStore, error) {
+	if len(files) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	s := &CertificateStore{files: files}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads the certificates from disk. If any of them fails to load, the
+// current certificates are kept.
+func (s *CertificateStore) Reload() error {
+	certs := make([]*tls.Certificate, 0, len(s.files))
+	byName := map[string]*tls.Certificate{}
+	modTimes := make([]time.Time, 0, len(s.files))
+
+	for _, f := range s.files {
+		cert, err := loadCertificate(f)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+		modTimes = append(modTimes, certificateModTime(f))
+
+		for _, name := range cert.Leaf.DNSNames {
+			name = strings.ToLower(name)
+			if _, ok := byName[name]; !ok {
+				byName[name] = cert
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.certs, s.byName, s.modTimes = certs, byName, modTimes
+	s.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Exact server names are
+// preferred over wildcard certificates.
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return s.certs[0], nil
+}
+
+// Watch polls the modification times of the certificate files, reloading the
+// certificates when any of them changed.
+func (s *CertificateStore) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			logger().Errorf("failed to reload certificates, keeping previous certificates: %v", err)
+			continue
+		}
+		logger().Infof("reloaded %d certificates", len(s.files))
+	}
+}
+
+func (s *CertificateStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i, f := range s.files {
+		if !certificateModTime(f).Equal(s.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadCertificate(f CertificateFiles) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate %s: %v", f.CertFile, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("invalid certificate %s: %v", f.CertFile, err)
+	}
+
+	if f.OCSPStapleFile != "" {
+		if cert.OCSPStaple, err = ioutil.ReadFile(f.OCSPStapleFile); err != nil {
+			return nil, fmt.Errorf("invalid OCSP staple %s: %v", f.OCSPStapleFile, err)
+		}
+	}
+	return &cert, nil
+}
+
+// certificateModTime returns the latest modification time of the files.
+func certificateModTime(f CertificateFiles) time.Time {
+	var modTime time.Time
+	for _, name := range []string{f.CertFile, f.KeyFile, f.OCSPStapleFile} {
+		if name == "" {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime
+}
+
+// TLSConfig configures an HTTPS listener of the gateway. By default TLS 1.2 is
+// the minimum version and both HTTP/2 and HTTP/1.1 are negotiated with ALPN.
+type TLSConfig struct {
+	certificates *CertificateStore
+	minVersion   uint16
+	cipherSuites []uint16
+	nextProtos   []string
+}
+
+func NewTLSConfig(certificates *CertificateStore) *TLSConfig {
+	return &TLSConfig{
+		certificates: certificates,
+		minVersion:   tls.VersionTLS12,
+		nextProtos:   []string{"h2", "http/1.1"},
+	}
+}
+
+func (c *TLSConfig) WithMinVersion(version uint16) *TLSConfig {
+	if version > 0 {
+		c.minVersion = version
+	}
+	return c
+}
+
+// WithCipherSuites restricts the cipher suites of TLS 1.2 and below, TLS 1.3
+// cipher suites are not configurable.
+func (c *TLSConfig) WithCipherSuites(cipherSuites ...uint16) *TLSConfig {
+	if len(cipherSuites) > 0 {
+		c.cipherSuites = cipherSuites
+	}
+	return c
+}
+
+func (c *TLSConfig) WithNextProtos(nextProtos ...string) *TLSConfig {
+	if len(nextProtos) > 0 {
+		c.nextProtos = nextProtos
+	}
+	return c
+}
+
+func (c *TLSConfig) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.certificates.GetCertificate,
+		MinVersion:     c.minVersion,
+		CipherSuites:   c.cipherSuites,
+		NextProtos:     c.nextProtos,
+	}
+}
+
+// http2Enabled reports whether HTTP/2 is offered with ALPN.
+func (c *TLSConfig) http2Enabled() bool {
+	for _, proto := range c.nextProtos {
+		if proto == "h2" {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTLSVersion parses a TLS version such as "1.2". An empty version
+// returns zero, i.e. the default.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf(ErrPatternUnknownTLSVersion, version)
+}
+
+// ParseCipherSuites parses cipher suite names such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf(ErrPatternUnknownCipherSuite, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for the names and returns
+// the certificate and key files.
+func writeCertificate(t *testing.T, dir, name string, dnsNames ...string) CertificateFiles {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(files.CertFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(files.KeyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCertificateStore_GetCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewCertificateStore(
+		writeCertificate(t, dir, "default", "default.example.com"),
+		writeCertificate(t, dir, "api", "api.example.com"),
+		writeCertificate(t, dir, "wildcard", "*.example.org"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		expected   string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"API.example.com.", "api.example.com"},
+		{"www.example.org", "*.example.org"},
+		{"a.b.example.org", "default.example.com"},
+		{"unknown.example.com", "default.example.com"},
+		{"", "default.example.com"},
+	}
+
+	for _, tt := range tests {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name := cert.Leaf.DNSNames[0]; name != tt.expected {
+			t.Errorf("invalid certificate for '%s', expected: %s, actual: %s", tt.serverName, tt.expected, name)
+		}
+	}
+}
+
+func TestCertificateStore_Reload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	files := writeCertificate(t, dir, "cert", "old.example.com")
+	store, err := NewCertificateStore(files)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeCertificate(t, dir, "cert", "new.example.com")
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert, _ := store.GetCertificate(&tls.ClientHelloInfo{}); cert.Leaf.DNSNames[0] != "new.example.com" {
+		t.Errorf("certificate not reloaded: %v", cert.Leaf.DNSNames)
+	}
+
+	if err := ioutil.WriteFile(files.CertFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("expected error for invalid certificate, none occurred")
+	}
+	if cert, _ := store.GetCertificate(&tls.ClientHelloInfo{}); cert.Leaf.DNSNames[0] != "new.example.com" {
+		t.Errorf("previous certificate not kept: %v", cert.Leaf.DNSNames)
+	}
+}
+
+func TestTLSConfig_Serve(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewCertificateStore(writeCertificate(t, dir, "api", "api.example.com"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.TLS = NewTLSConfig(store).WithMinVersion(tls.VersionTLS13).tlsConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	cert, _ := store.GetCertificate(&tls.ClientHelloInfo{})
+	pool.AddCert(cert.Leaf)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "api.example.com"},
+	}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("invalid TLS version, expected: %x, actual: %x", tls.VersionTLS13, resp.TLS.Version)
+	}
+}
+
+func TestParseTLSSettings(t *testing.T) {
+	if version, err := ParseTLSVersion("1.3"); err != nil || version != tls.VersionTLS13 {
+		t.Errorf("invalid TLS version: %x, %v", version, err)
+	}
+	if _, err := ParseTLSVersion("2.0"); err == nil {
+		t.Error("expected error for unknown TLS version, none occurred")
+	}
+
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(suites) != 1 || suites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("invalid cipher suites: %v, %v", suites, err)
+	}
+	if _, err := ParseCipherSuites([]string{"TLS_UNKNOWN"}); err == nil {
+		t.Error("expected error for unknown cipher suite, none occurred")
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cdmatta/api-gw/middleware"
+	"github.com/cdmatta/api-gw/tracing"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (e *recordingExporter) Export(_ string, spans []*tracing.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestReverseProxy_Tracing(t *testing.T) {
+	var traceparent string
+	backend := newCountingBackend(http.StatusServiceUnavailable)
+	backend.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.HeaderTraceparent)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer backend.Close()
+
+	exporter := &recordingExporter{}
+	tracer := tracing.NewTracer("api-gw", exporter)
+	gateway := NewReverseProxy().
+		WithGlobalFilterFunc(middleware.Compose(middleware.NewTracingMiddleware(tracer)))
+	if err := gateway.SetRoutes([]*Route{newTestRoute("/users/:id", backend)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set(tracing.HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	gateway.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Shutdown()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("invalid number of spans, expected: %d, actual: %d", 2, len(exporter.spans))
+	}
+	client, server := exporter.spans[0], exporter.spans[1]
+
+	if server.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("server span not continuing the trace of the caller: %+v", server.Context)
+	}
+	if server.Name != "GET /users/:id" || server.Attributes["http.route"] != "/users/:id" || !server.Error {
+		t.Errorf("invalid server span: %s, %v", server.Name, server.Attributes)
+	}
+	if client.Kind != tracing.SpanKindClient || client.ParentSpanID != server.Context.SpanID {
+		t.Errorf("client span not a child of the server span: %+v", client)
+	}
+	if client.Attributes["http.response.status_code"] != http.StatusServiceUnavailable || !client.Error {
+		t.Errorf("invalid client span attributes: %v", client.Attributes)
+	}
+
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + client.Context.SpanID.String() + "-01"
+	if traceparent != expected {
+		t.Errorf("invalid upstream traceparent, expected: '%s', actual: '%s'", expected, traceparent)
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	upstreamConnections = promauto.NewCounterVec(
+		prometheus.CounterOpts{Name: "gateway_upstream_connections_total"},
+		[]string{"route", "target", "reused"},
+	)
+	upstreamConnectionIdle = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "gateway_upstream_connection_idle_seconds"},
+		[]string{"route", "target"},
+	)
+)
+
+// ConnectionPool tunes the connections to the targets of a route. Zero values
+// keep the defaults of http.DefaultTransport.
+type ConnectionPool struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	KeepAlive           time.Duration
+	DisableHTTP2        bool
+}
+
+// newTransport returns the transport for the upstream requests of the route,
+// based on http.DefaultTransport. Routes without transport settings share
+// http.DefaultTransport and its connections.
+func newTransport(route *Route) http.RoundTripper {
+	timeouts, pool, upstreamTLS := route.timeouts, route.connectionPool, route.upstreamTLS
+	if timeouts.Connect == 0 && timeouts.ResponseHeader == 0 && pool == (ConnectionPool{}) && upstreamTLS == nil {
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if timeouts.Connect > 0 || pool.KeepAlive != 0 {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		if timeouts.Connect > 0 {
+			dialer.Timeout = timeouts.Connect
+		}
+		if pool.KeepAlive != 0 {
+			dialer.KeepAlive = pool.KeepAlive
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+
+	if pool.MaxIdleConns > 0 {
+		transport.MaxIdleConns = pool.MaxIdleConns
+	}
+	if pool.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	if pool.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = pool.IdleConnTimeout
+	}
+	if pool.DisableHTTP2 {
+		// A non-nil map keeps the transport from configuring HTTP/2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if upstreamTLS != nil {
+		transport.TLSClientConfig = upstreamTLS.tlsConfig()
+	}
+	return transport
+}
+
+// withConnectionTrace records whether the upstream request reused a pooled
+// connection, and how long the connection was idle.
+func withConnectionTrace(req *http.Request, route string, target *Target) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnections.WithLabelValues(route, target.String(), strconv.FormatBool(info.Reused)).Inc()
+			if info.WasIdle {
+				upstreamConnectionIdle.WithLabelValues(route, target.String()).Observe(info.IdleTime.Seconds())
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
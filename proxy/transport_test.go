@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewTransport(t *testing.T) {
+	if transport := newTransport(NewRoute()); transport != http.DefaultTransport {
+		t.Errorf("route without transport settings not using the default transport")
+	}
+
+	route := NewRoute().
+		WithTimeouts(Timeouts{ResponseHeader: time.Second}).
+		WithConnectionPool(ConnectionPool{
+			MaxIdleConns:        10,
+			MaxIdleConnsPerHost: 5,
+			MaxConnsPerHost:     20,
+			IdleConnTimeout:     time.Minute,
+			DisableHTTP2:        true,
+		})
+	transport := newTransport(route).(*http.Transport)
+
+	if transport.MaxIdleConns != 10 || transport.MaxIdleConnsPerHost != 5 || transport.MaxConnsPerHost != 20 {
+		t.Errorf("invalid connection limits: %d, %d, %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport.IdleConnTimeout != time.Minute || transport.ResponseHeaderTimeout != time.Second {
+		t.Errorf("invalid timeouts: %s, %s", transport.IdleConnTimeout, transport.ResponseHeaderTimeout)
+	}
+	if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil {
+		t.Error("HTTP/2 not disabled")
+	}
+}
+
+func TestRouteHandler_ConnectionTrace(t *testing.T) {
+	backend := newCountingBackend(http.StatusOK)
+	defer backend.Close()
+
+	target := backend.target()
+	route := NewRoute().
+		WithPath("/trace").
+		WithTargets(target).
+		WithConnectionPool(ConnectionPool{MaxIdleConnsPerHost: 1})
+	handler := newRouteHandler(route, nil)
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trace", nil))
+	}
+
+	counterValue := func(reused string) float64 {
+		families, _ := prometheus.DefaultGatherer.Gather()
+		for _, family := range families {
+			if family.GetName() != "gateway_upstream_connections_total" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if labels["route"] == "/trace" && labels["target"] == target.String() && labels["reused"] == reused {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+		return 0
+	}
+	if created, reused := counterValue("false"), counterValue("true"); created != 1 || reused != 2 {
+		t.Errorf("invalid connection counts, new: %v, reused: %v", created, reused)
+	}
+}
+
+func TestRoute_TransportReusedAndClosed(t *testing.T) {
+	var closed int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	route := NewRoute().
+		WithMethods([]string{http.MethodGet}).
+		WithPath("/test").
+		WithTargets(NewTarget(backendUrl)).
+		WithConnectionPool(ConnectionPool{MaxIdleConnsPerHost: 1})
+
+	if newRouteHandler(route, nil).reverseProxy.Transport != newRouteHandler(route, nil).reverseProxy.Transport {
+		t.Error("transport of route not reused")
+	}
+
+	gateway := NewReverseProxy()
+	if err := gateway.SetRoutes([]*Route{route}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code := serveGateway(gateway, "/test"); code != http.StatusOK {
+		t.Fatalf("invalid status, expected: %d, actual: %d", http.StatusOK, code)
+	}
+	if err := gateway.SetRoutes(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&closed) == 1 }, "idle connection of removed route to be closed")
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// UpstreamTLS configures the TLS connections to the targets of a route: the
+// CAs trusted in addition to the system CAs, the client certificate for mutual
+// TLS and the server name to verify instead of the target host.
+type UpstreamTLS struct {
+	rootCAs            *x509.CertPool
+	certificates       []tls.Certificate
+	serverName         string
+	insecureSkipVerify bool
+}
+
+func NewUpstreamTLS() *UpstreamTLS {
+	return &UpstreamTLS{}
+}
+
+// WithCA trusts the PEM encoded certificates of the CA bundle.
+func (u *UpstreamTLS) WithCA(caFile string) (*UpstreamTLS, error) {
+	if caFile == "" {
+		return u, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA bundle %s: %v", caFile, err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("invalid CA bundle %s: no certificates found", caFile)
+	}
+	u.rootCAs = rootCAs
+	return u, nil
+}
+
+// WithClientCertificate presents the certificate to targets requiring mutual
+// TLS.
+func (u *UpstreamTLS) WithClientCertificate(certFile, keyFile string) (*UpstreamTLS, error) {
+	if certFile == "" && keyFile == "" {
+		return u, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate %s: %v", certFile, err)
+	}
+	u.certificates = []tls.Certificate{cert}
+	return u, nil
+}
+
+func (u *UpstreamTLS) WithServerName(serverName string) *UpstreamTLS {
+	u.serverName = serverName
+	return u
+}
+
+// WithInsecureSkipVerify disables the verification of the target certificates.
+// Only meant for development.
+func (u *UpstreamTLS) WithInsecureSkipVerify(insecureSkipVerify bool) *UpstreamTLS {
+	u.insecureSkipVerify = insecureSkipVerify
+	return u
+}
+
+func (u *UpstreamTLS) tlsConfig() *tls.Config {
+	return &tls.Config{
+		RootCAs:            u.rootCAs,
+		Certificates:       u.certificates,
+		ServerName:         u.serverName,
+		InsecureSkipVerify: u.insecureSkipVerify,
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteHandler_UpstreamMutualTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	clientFiles := writeCertificate(t, dir, "client", "client.example.com")
+	clientCert, err := tls.LoadX509KeyPair(clientFiles.CertFile, clientFiles.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCert.Leaf, _ = x509.ParseCertificate(clientCert.Certificate[0])
+	clientCAs.AddCert(clientCert.Leaf)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.StartTLS()
+	defer backend.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		certFile       string
+		keyFile        string
+		serverName     string
+		expectedStatus int
+	}{
+		{"client certificate", clientFiles.CertFile, clientFiles.KeyFile, "", http.StatusOK},
+		{"server name override", clientFiles.CertFile, clientFiles.KeyFile, "example.com", http.StatusOK},
+		{"wrong server name", clientFiles.CertFile, clientFiles.KeyFile, "gateway.invalid", http.StatusBadGateway},
+		{"no client certificate", "", "", "", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamTLS, err := NewUpstreamTLS().WithServerName(tt.serverName).WithCA(caFile)
+			if err == nil {
+				upstreamTLS, err = upstreamTLS.WithClientCertificate(tt.certFile, tt.keyFile)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			backendUrl, _ := url.Parse(backend.URL)
+			route := NewRoute().
+				WithPath("/test").
+				WithTargets(NewTarget(backendUrl)).
+				WithUpstreamTLS(upstreamTLS)
+
+			w := httptest.NewRecorder()
+			newRouteHandler(route, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+			if w.Code != tt.expectedStatus {
+				t.Errorf("invalid status, expected: %d, actual: %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestUpstreamTLS_InvalidFiles(t *testing.T) {
+	if _, err := NewUpstreamTLS().WithCA("/nonexistent/ca.crt"); err == nil {
+		t.Error("expected error for missing CA bundle, none occurred")
+	}
+	if _, err := NewUpstreamTLS().WithClientCertificate("/nonexistent/client.crt", "/nonexistent/client.key"); err == nil {
+		t.Error("expected error for missing client certificate, none occurred")
+	}
+}
@@ -0,0 +1,317 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ExporterOTLPHTTP  = "otlp_http"
+	ExporterJSONLines = "jsonl"
+
+	batchSize     = 512
+	queueSize     = 2048
+	flushInterval = 5 * time.Second
+)
+
+var tracingSpansDropped = promauto.NewCounter(
+	prometheus.CounterOpts{Name: "gateway_tracing_spans_dropped_total"},
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(serviceName string, spans []*Span) error
+}
+
+// batchProcessor exports spans in batches in the background. Spans are
+// dropped when the exporter cannot keep up.
+type batchProcessor struct {
+	serviceName string
+	exporter    Exporter
+	queue       chan *Span
+	flush       chan chan struct{}
+	once        sync.Once
+}
+
+func newBatchProcessor(serviceName string, exporter Exporter) *batchProcessor {
+	p := &batchProcessor{
+		serviceName: serviceName,
+		exporter:    exporter,
+		queue:       make(chan *Span, queueSize),
+		flush:       make(chan chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *batchProcessor) enqueue(span *Span) {
+	select {
+	case p.queue <- span:
+	default:
+		tracingSpansDropped.Inc()
+	}
+}
+
+func (p *batchProcessor) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.exporter.Export(p.serviceName, batch); err != nil {
+			tracingSpansDropped.Add(float64(len(batch)))
+			logger().Warnf("failed to export %d spans: %v", len(batch), err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+
+	for {
+		select {
+		case span := <-p.queue:
+			if batch = append(batch, span); len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-p.flush:
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+			}
+			export()
+			close(done)
+			return
+		}
+	}
+}
+
+func (p *batchProcessor) shutdown() {
+	p.once.Do(func() {
+		done := make(chan struct{})
+		p.flush <- done
+		<-done
+	})
+}
+
+// OTLPHTTPExporter exports spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding.
+type OTLPHTTPExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewOTLPHTTPExporter exports to the traces endpoint of the collector, e.g.
+// http://localhost:4318/v1/traces.
+func NewOTLPHTTPExporter(endpoint string) *OTLPHTTPExporter {
+	return &OTLPHTTPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// WithHeaders sets additional request headers, e.g. for authentication.
+func (e *OTLPHTTPExporter) WithHeaders(headers map[string]string) *OTLPHTTPExporter {
+	e.headers = headers
+	return e
+}
+
+func (e *OTLPHTTPExporter) Export(serviceName string, spans []*Span) error {
+	body, err := json.Marshal(newOTLPTraces(serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// JSONLinesExporter appends one JSON object per span to a file, for local
+// runs without a collector.
+type JSONLinesExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONLinesExporter(filePath string) (*JSONLinesExporter, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesExporter{file: file}, nil
+}
+
+type jsonLinesSpan struct {
+	Service       string                 `json:"service"`
+	TraceId       string                 `json:"trace_id"`
+	SpanId        string                 `json:"span_id"`
+	ParentSpanId  string                 `json:"parent_span_id,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	DurationMs    float64                `json:"duration_ms"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Error         bool                   `json:"error,omitempty"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+func (e *JSONLinesExporter) Export(serviceName string, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := bufio.NewWriter(e.file)
+	enc := json.NewEncoder(w)
+	for _, s := range spans {
+		line := jsonLinesSpan{
+			Service:       serviceName,
+			TraceId:       s.Context.TraceID.String(),
+			SpanId:        s.Context.SpanID.String(),
+			Name:          s.Name,
+			Kind:          s.Kind.String(),
+			Start:         s.Start,
+			End:           s.End,
+			DurationMs:    float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+			Attributes:    s.Attributes,
+			Error:         s.Error,
+			StatusMessage: s.StatusMessage,
+		}
+		if s.ParentSpanID.IsValid() {
+			line.ParentSpanId = s.ParentSpanID.String()
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// The OTLP JSON encoding, see opentelemetry-proto. IDs are hex encoded and
+// 64 bit integers are strings.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceId           string         `json:"traceId"`
+		SpanId            string         `json:"spanId"`
+		ParentSpanId      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const otlpStatusError = 2
+
+func newOTLPTraces(serviceName string, spans []*Span) otlpTraces {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceId:           s.Context.TraceID.String(),
+			SpanId:            s.Context.SpanID.String(),
+			TraceState:        s.Context.TraceState,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.ParentSpanID.IsValid() {
+			span.ParentSpanId = s.ParentSpanID.String()
+		}
+		for key, value := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: key, Value: newOTLPValue(value)})
+		}
+		if s.Error {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.StatusMessage}
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: newOTLPValue(serviceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/cdmatta/api-gw/tracing"},
+			Spans: otlpSpans,
+		}},
+	}}}
+}
+
+func newOTLPValue(value interface{}) otlpValue {
+	switch v := value.(type) {
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	}
+	s := fmt.Sprint(value)
+	return otlpValue{StringValue: &s}
+}
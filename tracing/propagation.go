@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	PropagationW3C = "w3c"
+	PropagationB3  = "b3"
+
+	HeaderTraceparent = "Traceparent"
+	HeaderTracestate  = "Tracestate"
+	HeaderB3          = "B3"
+	HeaderB3TraceId   = "X-B3-Traceid"
+	HeaderB3SpanId    = "X-B3-Spanid"
+	HeaderB3Sampled   = "X-B3-Sampled"
+)
+
+// Propagator reads and writes span contexts from and to request headers.
+type Propagator interface {
+	Extract(header http.Header) (SpanContext, bool)
+	Inject(sc SpanContext, header http.Header)
+}
+
+// NewPropagator returns the propagator of the format, "w3c" or "b3".
+func NewPropagator(format string) (Propagator, error) {
+	switch format {
+	case PropagationW3C:
+		return TraceContextPropagator{}, nil
+	case PropagationB3:
+		return B3Propagator{}, nil
+	}
+	return nil, fmt.Errorf("unknown trace propagation format '%s'", format)
+}
+
+// TraceContextPropagator implements W3C Trace Context, i.e. the traceparent
+// and tracestate headers.
+type TraceContextPropagator struct{}
+
+func (TraceContextPropagator) Extract(header http.Header) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header.Get(HeaderTraceparent)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) || !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	sc.TraceState = strings.Join(header.Values(HeaderTracestate), ",")
+	return sc, true
+}
+
+func (TraceContextPropagator) Inject(sc SpanContext, header http.Header) {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	header.Set(HeaderTraceparent, "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		header.Set(HeaderTracestate, sc.TraceState)
+	} else {
+		header.Del(HeaderTracestate)
+	}
+}
+
+// B3Propagator implements Zipkin B3 propagation. Both the single b3 header and
+// the X-B3-* headers are read, the single header is written.
+type B3Propagator struct{}
+
+func (B3Propagator) Extract(header http.Header) (SpanContext, bool) {
+	traceId, spanId, sampled := header.Get(HeaderB3TraceId), header.Get(HeaderB3SpanId), header.Get(HeaderB3Sampled)
+	if b3 := header.Get(HeaderB3); b3 != "" {
+		parts := strings.Split(b3, "-")
+		if len(parts) < 2 {
+			return SpanContext{}, false
+		}
+		traceId, spanId = parts[0], parts[1]
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+	}
+
+	// 64 bit trace IDs are left-padded to 128 bits.
+	if len(traceId) == 16 {
+		traceId = strings.Repeat("0", 16) + traceId
+	}
+
+	var sc SpanContext
+	if !decodeHex(sc.TraceID[:], traceId) || !decodeHex(sc.SpanID[:], spanId) || !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = sampled == "1" || sampled == "d" || sampled == "true"
+	return sc, true
+}
+
+func (B3Propagator) Inject(sc SpanContext, header http.Header) {
+	sampled := "0"
+	if sc.Sampled {
+		sampled = "1"
+	}
+	header.Del(HeaderB3TraceId)
+	header.Del(HeaderB3SpanId)
+	header.Del(HeaderB3Sampled)
+	header.Set(HeaderB3, sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+sampled)
+}
+
+// decodeHex decodes the lower case hex string into dst, which it must fill
+// exactly.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		_, _ = rand.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		_, _ = rand.Read(s[:])
+	}
+	return s
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type SpanKind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	SpanKindServer SpanKind = 2
+	SpanKindClient SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// Span is a timed operation of a trace. A nil span is valid and does nothing,
+// so that callers need not check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+
+	mu            sync.Mutex
+	Attributes    map[string]interface{}
+	Error         bool
+	StatusMessage string
+	ended         bool
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute sets an attribute of the span. Values are strings, ints, int64s
+// or bools.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Error, s.StatusMessage = true, message
+	s.mu.Unlock()
+}
+
+// Finish ends the span and hands it to the exporter if the trace is sampled.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.End = true, time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled {
+		s.tracer.processor.enqueue(s)
+	}
+}
+
+// SpanContext returns the span context, the zero value for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span of the context, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"time"
+)
+
+// Tracer creates the spans of the gateway. Requests continue the trace of the
+// caller if the request carries a span context in any of the propagation
+// formats. Otherwise a new trace is started, which is sampled at the sample
+// ratio.
+type Tracer struct {
+	serviceName string
+	sampleRatio float64
+	propagators []Propagator
+	processor   *batchProcessor
+}
+
+// NewTracer returns a tracer exporting the sampled spans with the exporter.
+// By default all new traces are sampled and W3C Trace Context is propagated.
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	return &Tracer{
+		serviceName: serviceName,
+		sampleRatio: 1,
+		propagators: []Propagator{TraceContextPropagator{}},
+		processor:   newBatchProcessor(serviceName, exporter),
+	}
+}
+
+func (t *Tracer) WithSampleRatio(ratio float64) *Tracer {
+	if ratio >= 0 && ratio <= 1 {
+		t.sampleRatio = ratio
+	}
+	return t
+}
+
+// WithPropagators sets the propagation formats, the span context is extracted
+// using the first format found and injected in all formats.
+func (t *Tracer) WithPropagators(propagators ...Propagator) *Tracer {
+	if len(propagators) > 0 {
+		t.propagators = propagators
+	}
+	return t
+}
+
+// StartServerSpan starts the span of a request received by the gateway.
+func (t *Tracer) StartServerSpan(req *http.Request, name string) (context.Context, *Span) {
+	var parent SpanContext
+	for _, p := range t.propagators {
+		if sc, ok := p.Extract(req.Header); ok {
+			parent = sc
+			break
+		}
+	}
+	span := t.newSpan(name, SpanKindServer, parent)
+	return ContextWithSpan(req.Context(), span), span
+}
+
+// StartSpan starts a child span of the current span of the context. Without a
+// current span tracing is disabled and the span is nil.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.tracer.newSpan(name, kind, parent.Context)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Inject writes the span context of the span into the request headers, in all
+// propagation formats of the tracer.
+func (s *Span) Inject(header http.Header) {
+	if s == nil {
+		return
+	}
+	for _, p := range s.tracer.propagators {
+		p.Inject(s.Context, header)
+	}
+}
+
+// Shutdown exports the spans not exported yet.
+func (t *Tracer) Shutdown() {
+	t.processor.shutdown()
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, parent SpanContext) *Span {
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID, sc.Sampled, sc.TraceState = parent.TraceID, parent.Sampled, parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+
+	return &Span{
+		tracer:       t,
+		Name:         name,
+		Kind:         kind,
+		Context:      sc,
+		ParentSpanID: parent.SpanID,
+		Start:        time.Now(),
+		Attributes:   map[string]interface{}{},
+	}
+}
+
+// sample decides on the trace ID, so that the decision is the same for all
+// spans of a trace.
+func (t *Tracer) sample(traceId TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	x := binary.BigEndian.Uint64(traceId[8:]) >> 1
+	return float64(x) < t.sampleRatio*(1<<63)
+}
@@ -0,0 +1,198 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceContextPropagator(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		valid       bool
+		sampled     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set(HeaderTraceparent, tt.traceparent)
+		header.Set(HeaderTracestate, "vendor=value")
+
+		sc, ok := TraceContextPropagator{}.Extract(header)
+		if ok != tt.valid || sc.Sampled != tt.sampled {
+			t.Errorf("invalid span context of '%s': %+v, %v", tt.traceparent, sc, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		injected := http.Header{}
+		TraceContextPropagator{}.Inject(sc, injected)
+		if injected.Get(HeaderTracestate) != "vendor=value" || injected.Get(HeaderTraceparent)[3:] != tt.traceparent[3:55] {
+			t.Errorf("invalid injected headers: %v", injected)
+		}
+	}
+}
+
+func TestB3Propagator(t *testing.T) {
+	tests := []struct {
+		header  http.Header
+		traceId string
+		sampled bool
+	}{
+		{http.Header{HeaderB3: {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"}}, "80f198ee56343ba864fe8b2a57d3eff7", true},
+		{http.Header{HeaderB3: {"64fe8b2a57d3eff7-e457b5a2e4d86bd1"}}, "000000000000000064fe8b2a57d3eff7", false},
+		{http.Header{
+			HeaderB3TraceId: {"80f198ee56343ba864fe8b2a57d3eff7"},
+			HeaderB3SpanId:  {"e457b5a2e4d86bd1"},
+			HeaderB3Sampled: {"1"},
+		}, "80f198ee56343ba864fe8b2a57d3eff7", true},
+	}
+
+	for _, tt := range tests {
+		sc, ok := B3Propagator{}.Extract(tt.header)
+		if !ok || sc.TraceID.String() != tt.traceId || sc.Sampled != tt.sampled {
+			t.Errorf("invalid span context of %v: %+v, %v", tt.header, sc, ok)
+		}
+	}
+
+	if _, ok := (B3Propagator{}).Extract(http.Header{HeaderB3: {"0"}}); ok {
+		t.Error("span context extracted from deny sampling header")
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	tracer := NewTracer("test", &recordingExporter{}).WithSampleRatio(0.25)
+	defer tracer.Shutdown()
+
+	sampled := 0
+	for i := 0; i < 4000; i++ {
+		_, span := tracer.StartServerSpan(httptest.NewRequest(http.MethodGet, "/", nil), "test")
+		if span.Context.Sampled {
+			sampled++
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Errorf("invalid number of sampled traces, expected about: %d, actual: %d", 1000, sampled)
+	}
+
+	// The decision of the caller is kept.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, span := NewTracer("test", &recordingExporter{}).WithSampleRatio(0).StartServerSpan(req, "test"); !span.Context.Sampled {
+		t.Error("sampled parent not sampled")
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer("test", exporter)
+
+	if _, span := StartSpan(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "disabled", SpanKindClient); span != nil {
+		t.Fatal("span started without tracing")
+	}
+
+	ctx, server := tracer.StartServerSpan(httptest.NewRequest(http.MethodGet, "/", nil), "server")
+	_, client := StartSpan(ctx, "client", SpanKindClient)
+	client.SetError("failed")
+	client.Finish()
+	server.Finish()
+	tracer.Shutdown()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("invalid number of exported spans, expected: %d, actual: %d", 2, len(exporter.spans))
+	}
+	if client.Context.TraceID != server.Context.TraceID || client.ParentSpanID != server.Context.SpanID {
+		t.Errorf("client span not a child of the server span: %+v, %+v", client.Context, server.Context)
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	var body otlpTraces
+	var authorization string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer("api-gw", NewOTLPHTTPExporter(collector.URL).WithHeaders(map[string]string{"Authorization": "Bearer token"}))
+	_, span := tracer.StartServerSpan(httptest.NewRequest(http.MethodGet, "/", nil), "GET /users/:id")
+	span.SetAttribute("http.response.status_code", 200)
+	span.Finish()
+	tracer.Shutdown()
+
+	if authorization != "Bearer token" {
+		t.Errorf("invalid authorization header: '%s'", authorization)
+	}
+	if len(body.ResourceSpans) != 1 || len(body.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("invalid export request: %+v", body)
+	}
+	exported := body.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if exported.TraceId != span.Context.TraceID.String() || exported.Kind != SpanKindServer || *exported.Attributes[0].Value.IntValue != "200" {
+		t.Errorf("invalid exported span: %+v", exported)
+	}
+}
+
+func TestJSONLinesExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "traces.jsonl")
+	exporter, err := NewJSONLinesExporter(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tracer := NewTracer("api-gw", exporter)
+	for i := 0; i < 3; i++ {
+		_, span := tracer.StartServerSpan(httptest.NewRequest(http.MethodGet, "/", nil), "test")
+		span.Finish()
+	}
+	tracer.Shutdown()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		var span jsonLinesSpan
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil || span.Service != "api-gw" || span.Kind != "server" {
+			t.Errorf("invalid span line: %s", scanner.Text())
+		}
+	}
+	if lines != 3 {
+		t.Errorf("invalid number of span lines, expected: %d, actual: %d", 3, lines)
+	}
+}
+
+type recordingExporter struct {
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(_ string, spans []*Span) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}